@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	astriaGrpc "buf.build/gen/go/astria/execution-apis/grpc/go/astria/execution/v1alpha2/executionv1alpha2grpc"
+	astriaPb "buf.build/gen/go/astria/execution-apis/protocolbuffers/go/astria/execution/v1alpha2"
+	sequencerClient "github.com/astriaorg/go-sequencer-client/client"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	messengerv1 "github.com/astriaorg/messenger-rollup/gen/messenger/v1"
+	"github.com/astriaorg/messenger-rollup/messenger"
+	"github.com/astriaorg/messenger-rollup/messenger/client"
+)
+
+// newClient returns a REST client, solving proofs of work of the given
+// difficulty.
+func (g *globals) newClient(powDifficulty int) (*client.Client, error) {
+	opts := []client.Option{client.WithPowDifficulty(powDifficulty)}
+	if g.token != "" {
+		opts = append(opts, client.WithToken(g.token))
+	}
+	if g.ca != "" || g.cert != "" {
+		tlsConfig, err := g.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, client.WithTLSConfig(tlsConfig))
+	}
+	return client.New(g.api, opts...)
+}
+
+// tlsConfig returns the TLS config of the -ca, -cert and -key flags.
+func (g *globals) tlsConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if g.ca != "" {
+		data, err := os.ReadFile(g.ca)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in %s", g.ca)
+		}
+	}
+	if g.cert != "" {
+		cert, err := tls.LoadX509KeyPair(g.cert, g.key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// dialGrpc connects to the gRPC API.
+func (g *globals) dialGrpc() (*grpc.ClientConn, error) {
+	creds := insecure.NewCredentials()
+	if g.tls || g.ca != "" || g.cert != "" {
+		tlsConfig, err := g.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		creds = credentials.NewTLS(tlsConfig)
+	}
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	if g.token != "" {
+		opts = append(opts, grpc.WithPerRPCCredentials(bearerToken(g.token)))
+	}
+	return grpc.Dial(g.grpc, opts...)
+}
+
+// bearerToken sends a token as the authorization metadata of gRPC calls.
+type bearerToken string
+
+func (t bearerToken) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+// RequireTransportSecurity is false, as the gRPC API may be served without
+// TLS.
+func (t bearerToken) RequireTransportSecurity() bool {
+	return false
+}
+
+// printJSON prints a value as indented JSON.
+func printJSON(v interface{}) error {
+	out, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+// submitFlags parses the flags shared by send, reply and react and checks
+// the number of positional arguments.
+func submitFlags(name string, args []string, nargs int, channel *string) (*flag.FlagSet, string, int, error) {
+	fs := newFlagSet(name)
+	sender := fs.String("sender", envOr("MESSENGER_SENDER", ""), "sender name, or MESSENGER_SENDER")
+	pow := fs.Int("pow", 0, "proof of work difficulty required by the rollup")
+	if channel != nil {
+		fs.StringVar(channel, "channel", "", "channel, the default channel if empty")
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, "", 0, err
+	}
+	if *sender == "" {
+		return nil, "", 0, errors.New("-sender is required")
+	}
+	if fs.NArg() < nargs {
+		fs.Usage()
+		return nil, "", 0, flag.ErrHelp
+	}
+	return fs, *sender, *pow, nil
+}
+
+func runSend(ctx context.Context, g *globals, args []string) error {
+	var channel string
+	fs, sender, pow, err := submitFlags("send", args, 1, &channel)
+	if err != nil {
+		return err
+	}
+	c, err := g.newClient(pow)
+	if err != nil {
+		return err
+	}
+	return c.SendMessage(ctx, sender, channel, strings.Join(fs.Args(), " "))
+}
+
+func runReply(ctx context.Context, g *globals, args []string) error {
+	fs, sender, pow, err := submitFlags("reply", args, 2, nil)
+	if err != nil {
+		return err
+	}
+	c, err := g.newClient(pow)
+	if err != nil {
+		return err
+	}
+	return c.Reply(ctx, sender, fs.Arg(0), strings.Join(fs.Args()[1:], " "))
+}
+
+func runReact(ctx context.Context, g *globals, args []string) error {
+	fs, sender, pow, err := submitFlags("react", args, 2, nil)
+	if err != nil {
+		return err
+	}
+	c, err := g.newClient(pow)
+	if err != nil {
+		return err
+	}
+	return c.React(ctx, sender, fs.Arg(0), fs.Arg(1))
+}
+
+// formatTx formats a transaction on one line.
+func formatTx(tx *messenger.StoredTx) string {
+	switch tx.Kind() {
+	case messenger.TxTypeReply:
+		return fmt.Sprintf("%s #%s %s (reply to %s): %s", tx.Timestamp.Format(time.RFC3339), tx.ChannelName(), tx.Sender, tx.ParentID, tx.Message)
+	case messenger.TxTypeReaction:
+		return fmt.Sprintf("%s #%s %s reacted %s to %s", tx.Timestamp.Format(time.RFC3339), tx.ChannelName(), tx.Sender, tx.Reaction, tx.ParentID)
+	default:
+		return fmt.Sprintf("%s #%s %s: %s", tx.Timestamp.Format(time.RFC3339), tx.ChannelName(), tx.Sender, tx.Message)
+	}
+}
+
+func runTail(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("tail")
+	var channels, senders stringList
+	fs.Var(&channels, "channel", "only print this channel, may be repeated")
+	fs.Var(&senders, "sender", "only print this sender, may be repeated")
+	commitment := fs.String("commitment", string(messenger.CommitmentExecuted), "commitment level: executed, soft or firm")
+	from := fs.Int64("from", -1, "replay from this height before printing new blocks")
+	asJSON := fs.Bool("json", false, "print every block as a JSON line")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	c, err := g.newClient(0)
+	if err != nil {
+		return err
+	}
+	opts := client.SubscribeOptions{
+		Channels:   channels,
+		Senders:    senders,
+		Commitment: messenger.CommitmentLevel(*commitment),
+	}
+	if *from >= 0 {
+		height := uint32(*from)
+		opts.FromHeight = &height
+	}
+
+	sub := c.Subscribe(ctx, opts)
+	defer sub.Close()
+	for block := range sub.Events() {
+		if *asJSON {
+			out, err := json.Marshal(block)
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+			continue
+		}
+		for _, tx := range block.Transactions {
+			fmt.Printf("[%d] %s %s\n", block.Height, tx.ID, formatTx(&tx.StoredTx))
+		}
+	}
+	return nil
+}
+
+func runBlock(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("block")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+	height, err := strconv.ParseUint(fs.Arg(0), 10, 32)
+	if err != nil {
+		return fmt.Errorf("invalid height: %w", err)
+	}
+
+	conn, err := g.dialGrpc()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	block, err := messengerv1.NewMessengerQueryServiceClient(conn).GetBlock(ctx, &messengerv1.GetBlockRequest{
+		Identifier: &messengerv1.GetBlockRequest_Height{Height: uint32(height)},
+	})
+	if err != nil {
+		return err
+	}
+	return printProto(block)
+}
+
+func runTx(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("tx")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	conn, err := g.dialGrpc()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	tx, err := messengerv1.NewMessengerQueryServiceClient(conn).GetTx(ctx, &messengerv1.GetTxRequest{Id: fs.Arg(0)})
+	if err != nil {
+		return err
+	}
+	return printProto(tx)
+}
+
+// printProto prints a protobuf message as indented JSON.
+func printProto(m proto.Message) error {
+	out, err := protojson.MarshalOptions{Multiline: true, Indent: "  "}.Marshal(m)
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(out))
+	return nil
+}
+
+func runCommitment(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("commitment")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	conn, err := g.dialGrpc()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	state, err := astriaGrpc.NewExecutionServiceClient(conn).GetCommitmentState(ctx, &astriaPb.GetCommitmentStateRequest{})
+	if err != nil {
+		return err
+	}
+	return printJSON(map[string]interface{}{
+		"soft": map[string]interface{}{
+			"height": state.Soft.Number,
+			"hash":   hex.EncodeToString(state.Soft.Hash),
+		},
+		"firm": map[string]interface{}{
+			"height": state.Firm.Number,
+			"hash":   hex.EncodeToString(state.Firm.Hash),
+		},
+	})
+}
+
+func runKeygen(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("keygen")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	signer, err := sequencerClient.GenerateSigner()
+	if err != nil {
+		return err
+	}
+	seed := signer.Seed()
+	address := signer.Address()
+	return printJSON(map[string]string{
+		// the seed is the SEQUENCER_PRIVATE of the node
+		"privateKey": hex.EncodeToString(seed[:ed25519.SeedSize]),
+		"publicKey":  hex.EncodeToString(signer.PublicKey()),
+		"address":    hex.EncodeToString(address[:]),
+	})
+}
+
+func runExport(ctx context.Context, g *globals, args []string) error {
+	fs := newFlagSet("export")
+	channel := fs.String("channel", "", "only export this channel")
+	sender := fs.String("sender", "", "only export this sender")
+	since := fs.String("since", "", "only export messages since this RFC 3339 time")
+	until := fs.String("until", "", "only export messages until this RFC 3339 time")
+	format := fs.String("format", "jsonl", "output format: jsonl or csv")
+	output := fs.String("o", "", "output file, stdout if empty")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	q := client.MessageQuery{
+		Channel:   *channel,
+		Sender:    *sender,
+		Ascending: true,
+	}
+	var err error
+	if *since != "" {
+		if q.Since, err = time.Parse(time.RFC3339, *since); err != nil {
+			return fmt.Errorf("invalid -since: %w", err)
+		}
+	}
+	if *until != "" {
+		if q.Until, err = time.Parse(time.RFC3339, *until); err != nil {
+			return fmt.Errorf("invalid -until: %w", err)
+		}
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	var write func(tx messenger.StoredTx) error
+	var flush func() error
+	switch *format {
+	case "jsonl":
+		enc := json.NewEncoder(w)
+		write = func(tx messenger.StoredTx) error {
+			return enc.Encode(tx)
+		}
+		flush = func() error { return nil }
+	case "csv":
+		cw := csv.NewWriter(w)
+		if err := cw.Write([]string{"id", "height", "timestamp", "type", "channel", "sender", "parentId", "message"}); err != nil {
+			return err
+		}
+		write = func(tx messenger.StoredTx) error {
+			return cw.Write([]string{
+				tx.ID,
+				strconv.FormatUint(uint64(tx.Height), 10),
+				tx.Timestamp.Format(time.RFC3339),
+				string(tx.Kind()),
+				tx.ChannelName(),
+				tx.Sender,
+				tx.ParentID,
+				tx.Message,
+			})
+		}
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+
+	c, err := g.newClient(0)
+	if err != nil {
+		return err
+	}
+	if err := c.Paginate(ctx, q, write); err != nil {
+		return err
+	}
+	return flush()
+}
@@ -0,0 +1,136 @@
+// messenger-cli interacts with a messenger rollup node over its REST,
+// WebSocket and gRPC APIs.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// command is a messenger-cli subcommand.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, g *globals, args []string) error
+}
+
+// globals are the flags shared by every command.
+type globals struct {
+	api   string
+	grpc  string
+	token string
+	// tls enables TLS on the gRPC API, ca and cert imply it. ca is trusted
+	// and cert and key are the client certificate of nodes requiring mTLS,
+	// on both the REST and gRPC APIs
+	tls  bool
+	ca   string
+	cert string
+	key  string
+}
+
+// commands is set in init, as the commands refer back to it for their usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"send", "send -sender NAME [-channel CHANNEL] MESSAGE", "send a message", runSend},
+		{"reply", "reply -sender NAME PARENT_ID MESSAGE", "reply to a message", runReply},
+		{"react", "react -sender NAME PARENT_ID REACTION", "react to a message", runReact},
+		{"tail", "tail [-channel CHANNEL]... [-sender NAME]... [-commitment LEVEL] [-from HEIGHT] [-json]", "print new transactions as they are executed", runTail},
+		{"block", "block HEIGHT", "print a block", runBlock},
+		{"tx", "tx ID", "print a transaction", runTx},
+		{"commitment", "commitment", "print the soft and firm commitment state", runCommitment},
+		{"keygen", "keygen", "generate a sequencer key", runKeygen},
+		{"export", "export [-channel CHANNEL] [-sender NAME] [-since TIME] [-until TIME] [-format jsonl|csv] [-o FILE]", "export message history", runExport},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: messenger-cli [-api URL] [-grpc ADDR] [-token TOKEN] [-tls] [-ca FILE] [-cert FILE -key FILE] COMMAND [ARGS]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-12s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun messenger-cli COMMAND -h for the arguments of a command\n")
+}
+
+// envOr returns the environment variable if set, def otherwise.
+func envOr(name string, def string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+	return def
+}
+
+func main() {
+	log.SetLevel(log.WarnLevel)
+
+	g := &globals{}
+	flag.StringVar(&g.api, "api", envOr("MESSENGER_API", "http://localhost:8080"), "REST API url, or MESSENGER_API")
+	flag.StringVar(&g.grpc, "grpc", envOr("MESSENGER_GRPC", "localhost:50051"), "gRPC API address, or MESSENGER_GRPC")
+	flag.StringVar(&g.token, "token", os.Getenv("MESSENGER_TOKEN"), "API key or token of nodes requiring authentication, or MESSENGER_TOKEN")
+	flag.BoolVar(&g.tls, "tls", os.Getenv("MESSENGER_TLS") == "true", "use TLS on the gRPC API, or MESSENGER_TLS=true")
+	flag.StringVar(&g.ca, "ca", os.Getenv("MESSENGER_CA"), "PEM CA file trusted for TLS, or MESSENGER_CA")
+	flag.StringVar(&g.cert, "cert", os.Getenv("MESSENGER_CERT"), "PEM client certificate file for mTLS, or MESSENGER_CERT")
+	flag.StringVar(&g.key, "key", os.Getenv("MESSENGER_KEY"), "PEM client key file for mTLS, or MESSENGER_KEY")
+	flag.Usage = usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		usage()
+		os.Exit(2)
+	}
+	name, args := flag.Arg(0), flag.Args()[1:]
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(ctx, g, args); err != nil {
+			if err == flag.ErrHelp {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "messenger-cli %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// newFlagSet returns the flag set of a command, printing its usage line.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(os.Stderr, "usage: messenger-cli %s\n", cmd.usage)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// stringList is a flag which may be repeated.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/sethvargo/go-envconfig"
+	"gopkg.in/yaml.v3"
+
+	"github.com/astriaorg/messenger-rollup/messenger"
+)
+
+// configKey describes a messenger.Config field. Its env var name is also its
+// config file key, in lower case, and its flag, in lower case with dashes.
+type configKey struct {
+	env      string
+	def      string
+	required bool
+	field    int
+}
+
+// configKeys returns the keys of messenger.Config in field order.
+func configKeys() []configKey {
+	t := reflect.TypeOf(messenger.Config{})
+	keys := make([]configKey, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, ok := field.Tag.Lookup("env")
+		if !ok {
+			continue
+		}
+		parts := strings.Split(tag, ",")
+		key := configKey{
+			env:   strings.TrimSpace(parts[0]),
+			field: i,
+		}
+		for _, opt := range parts[1:] {
+			opt = strings.TrimSpace(opt)
+			switch {
+			case opt == "required":
+				key.required = true
+			case strings.HasPrefix(opt, "default="):
+				key.def = strings.TrimPrefix(opt, "default=")
+			}
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func (k configKey) flagName() string {
+	return strings.ReplaceAll(strings.ToLower(k.env), "_", "-")
+}
+
+func (k configKey) fileKey() string {
+	return strings.ToLower(k.env)
+}
+
+// configFlags are the flags overriding config values.
+type configFlags struct {
+	fs   *flag.FlagSet
+	path *string
+}
+
+// addConfigFlags adds a -config flag and a flag per config key to fs.
+func addConfigFlags(fs *flag.FlagSet) *configFlags {
+	f := &configFlags{
+		fs:   fs,
+		path: fs.String("config", os.Getenv("MESSENGER_CONFIG"), "TOML or YAML config file, or MESSENGER_CONFIG"),
+	}
+	for _, key := range configKeys() {
+		usage := "overrides " + key.env
+		if key.def != "" {
+			usage += ", default " + key.def
+		}
+		fs.String(key.flagName(), "", usage)
+	}
+	return f
+}
+
+// values returns the config values of the flags that were set, by env var
+// name.
+func (f *configFlags) values() map[string]string {
+	byFlag := make(map[string]string)
+	for _, key := range configKeys() {
+		byFlag[key.flagName()] = key.env
+	}
+	values := make(map[string]string)
+	f.fs.Visit(func(fl *flag.Flag) {
+		if env, ok := byFlag[fl.Name]; ok {
+			values[env] = fl.Value.String()
+		}
+	})
+	return values
+}
+
+// load reads the config from, in order of precedence, the flags, the
+// environment, the config file and the defaults, and validates it.
+func (f *configFlags) load(ctx context.Context) (messenger.Config, error) {
+	lookupers := []envconfig.Lookuper{
+		envconfig.MapLookuper(f.values()),
+		envconfig.OsLookuper(),
+	}
+	if *f.path != "" {
+		values, err := readConfigFile(*f.path)
+		if err != nil {
+			return messenger.Config{}, err
+		}
+		lookupers = append(lookupers, envconfig.MapLookuper(values))
+	}
+
+	var cfg messenger.Config
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &cfg,
+		Lookuper: envconfig.MultiLookuper(lookupers...),
+	}); err != nil {
+		return messenger.Config{}, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return messenger.Config{}, fmt.Errorf("invalid config:\n%w", err)
+	}
+	return cfg, nil
+}
+
+// readConfigFile reads a TOML or YAML config file, depending on its
+// extension, into config values by env var name. Keys of nested tables are
+// joined with underscores, so rate_limit.ip_burst is RATE_LIMIT_IP_BURST.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := make(map[string]interface{})
+	switch ext := filepath.Ext(path); ext {
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unknown extension %q, expected .toml, .yaml or .yml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	known := make(map[string]bool)
+	for _, key := range configKeys() {
+		known[key.env] = true
+	}
+	values := make(map[string]string)
+	if err := flattenConfig("", raw, values); err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	for env := range values {
+		if !known[env] {
+			return nil, fmt.Errorf("config file %s: unknown key %s", path, strings.ToLower(env))
+		}
+	}
+	return values, nil
+}
+
+func flattenConfig(prefix string, raw map[string]interface{}, values map[string]string) error {
+	for k, v := range raw {
+		env := prefix + strings.ToUpper(strings.ReplaceAll(k, "-", "_"))
+		if table, ok := v.(map[string]interface{}); ok {
+			if err := flattenConfig(env+"_", table, values); err != nil {
+				return err
+			}
+			continue
+		}
+		value, err := configValueString(v)
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.ToLower(env), err)
+		}
+		values[env] = value
+	}
+	return nil
+}
+
+// configValueString formats a config file value the way envconfig parses it.
+func configValueString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case time.Time:
+		return v.Format(time.RFC3339), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValueString(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value %v", v)
+	}
+}
+
+// configField is a config value formatted for printing.
+type configField struct {
+	key   configKey
+	value interface{}
+}
+
+// configFields returns the values of cfg in field order, redacting secrets if
+// redact is set.
+func configFields(cfg messenger.Config, redact bool) []configField {
+	if redact {
+		cfg = cfg.Redacted()
+	}
+	v := reflect.ValueOf(cfg)
+	keys := configKeys()
+	fields := make([]configField, 0, len(keys))
+	for _, key := range keys {
+		var value interface{}
+		switch field := v.Field(key.field).Interface().(type) {
+		case time.Duration:
+			value = field.String()
+		case []string:
+			// unset lists are printed empty rather than left out
+			if field == nil {
+				field = []string{}
+			}
+			value = field
+		default:
+			value = field
+		}
+		fields = append(fields, configField{key, value})
+	}
+	return fields
+}
+
+// writeConfigTOML writes cfg as a TOML config file. Empty values without a
+// default are commented out.
+func writeConfigTOML(w io.Writer, cfg messenger.Config, redact bool) error {
+	for _, field := range configFields(cfg, redact) {
+		// the TOML encoder quotes strings and lists, a single key table
+		// encodes to a single line
+		var buf bytes.Buffer
+		if err := toml.NewEncoder(&buf).Encode(map[string]interface{}{field.key.fileKey(): field.value}); err != nil {
+			return fmt.Errorf("%s: %w", field.key.fileKey(), err)
+		}
+		line := strings.TrimSpace(buf.String())
+		value := reflect.ValueOf(field.value)
+		empty := (value.Kind() == reflect.String || value.Kind() == reflect.Slice) && value.Len() == 0
+		if empty && field.key.def == "" && !field.key.required {
+			line = "# " + line
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configJSON returns cfg as a map by config file key, for printing as JSON.
+func configJSON(cfg messenger.Config, redact bool) map[string]interface{} {
+	values := make(map[string]interface{})
+	for _, field := range configFields(cfg, redact) {
+		values[field.key.fileKey()] = field.value
+	}
+	return values
+}
+
+// configString formats cfg on a single line for logging, with secrets
+// redacted.
+func configString(cfg messenger.Config) string {
+	fields := configFields(cfg, true)
+	parts := make([]string, 0, len(fields))
+	for _, field := range fields {
+		parts = append(parts, fmt.Sprintf("%s=%v", field.key.env, field.value))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/sethvargo/go-envconfig"
+
+	"github.com/astriaorg/messenger-rollup/messenger"
+)
+
+// loadConfigFile loads a config file as the config flags do, on top of the
+// environment and the defaults.
+func loadConfigFile(t *testing.T, path string) (messenger.Config, error) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags := addConfigFlags(fs)
+	if err := fs.Parse([]string{"-config", path}); err != nil {
+		t.Fatal(err)
+	}
+	return flags.load(context.Background())
+}
+
+// configFromValues processes config values by env var name.
+func configFromValues(t *testing.T, values map[string]string) messenger.Config {
+	t.Helper()
+	var cfg messenger.Config
+	if err := envconfig.ProcessWith(context.Background(), &envconfig.Config{
+		Target:   &cfg,
+		Lookuper: envconfig.MapLookuper(values),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+func TestInitWritesLoadableConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "messenger.toml")
+	if err := runInit(context.Background(), []string{"-config", path}); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfigFile(t, path)
+	if err != nil {
+		t.Fatalf("loading the config written by init: %s", err)
+	}
+	if want := []string{"*"}; !reflect.DeepEqual(cfg.CORSAllowedOrigins, want) {
+		t.Errorf("CORSAllowedOrigins = %q, want %q", cfg.CORSAllowedOrigins, want)
+	}
+}
+
+func TestWriteConfigTOMLRoundTrip(t *testing.T) {
+	values := map[string]string{
+		"SEQUENCER_PRIVATE":        "0101010101010101010101010101010101010101010101010101010101010101",
+		"CORS_ALLOWED_ORIGINS":     "https://a.example,https://*.b.example",
+		"WEBHOOK_ALLOWED_NETWORKS": "10.0.0.0/8,fd00::/8",
+		"AUTH_JWT_ISSUER":          `issuer "quoted" \ backslash`,
+		"TRACING_SAMPLE_RATIO":     "0.25",
+	}
+	for k, v := range initValues {
+		values[k] = v
+	}
+	written := configFromValues(t, values)
+
+	path := filepath.Join(t.TempDir(), "messenger.toml")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeConfigTOML(f, written, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	read, err := readConfigFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := configFromValues(t, read); !reflect.DeepEqual(got, written) {
+		t.Errorf("config read back differs:\n got %+v\nwant %+v", got, written)
+	}
+}
+
+func TestConfigPrintedRedacted(t *testing.T) {
+	secrets := map[string]string{
+		"SEQUENCER_PRIVATE": "0101010101010101010101010101010101010101010101010101010101010101",
+		"ADMIN_TOKEN":       "admin-token-0123456789",
+		"AUTH_HMAC_SECRET":  "hmac-secret-0123456789abcdef0123456789",
+	}
+	values := map[string]string{}
+	for k, v := range initValues {
+		values[k] = v
+	}
+	for k, v := range secrets {
+		values[k] = v
+	}
+	cfg := configFromValues(t, values)
+
+	var toml strings.Builder
+	if err := writeConfigTOML(&toml, cfg, true); err != nil {
+		t.Fatal(err)
+	}
+	// encoded as by config show, without escaping the brackets
+	var jsonOut strings.Builder
+	enc := json.NewEncoder(&jsonOut)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(configJSON(cfg, true)); err != nil {
+		t.Fatal(err)
+	}
+	for name, printed := range map[string]string{
+		"config show":              toml.String(),
+		"config show -format json": jsonOut.String(),
+		"startup log":              configString(cfg),
+		"String":                   cfg.String(),
+	} {
+		for key, secret := range secrets {
+			if strings.Contains(printed, secret) {
+				t.Errorf("%s prints %s", name, key)
+			}
+		}
+		if got := strings.Count(printed, messenger.Redacted); got != len(secrets) {
+			t.Errorf("%s redacts %d values, want %d", name, got, len(secrets))
+		}
+	}
+}
@@ -61,7 +61,8 @@ function App() {
   useEffect(() => {
     ws.current = new WebSocket(import.meta.env.VITE_APP_WEBSOCKET_URL);
     ws.current.onmessage = (event) => {
-      const data = JSON.parse(event.data);
+      const envelope = JSON.parse(event.data);
+      const data = envelope.type === 'transactions' ? envelope.data : null;
       if (data && data.length) {
         const newMessages: Message[] = [];
         for (const msg of data) {
@@ -0,0 +1,260 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: messenger/v1/query.proto
+
+package messengerv1
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	MessengerQueryService_GetMessages_FullMethodName       = "/messenger.v1.MessengerQueryService/GetMessages"
+	MessengerQueryService_GetBlock_FullMethodName          = "/messenger.v1.MessengerQueryService/GetBlock"
+	MessengerQueryService_GetTx_FullMethodName             = "/messenger.v1.MessengerQueryService/GetTx"
+	MessengerQueryService_SubscribeMessages_FullMethodName = "/messenger.v1.MessengerQueryService/SubscribeMessages"
+)
+
+// MessengerQueryServiceClient is the client API for MessengerQueryService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type MessengerQueryServiceClient interface {
+	// GetMessages returns a page of messages and replies.
+	GetMessages(ctx context.Context, in *GetMessagesRequest, opts ...grpc.CallOption) (*GetMessagesResponse, error)
+	// GetBlock returns a block by height, or the latest block at a
+	// commitment level.
+	GetBlock(ctx context.Context, in *GetBlockRequest, opts ...grpc.CallOption) (*Block, error)
+	// GetTx returns a transaction by id.
+	GetTx(ctx context.Context, in *GetTxRequest, opts ...grpc.CallOption) (*StoredTransaction, error)
+	// SubscribeMessages streams the transactions of every block matching the
+	// filters once it reaches the commitment level.
+	SubscribeMessages(ctx context.Context, in *SubscribeMessagesRequest, opts ...grpc.CallOption) (MessengerQueryService_SubscribeMessagesClient, error)
+}
+
+type messengerQueryServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMessengerQueryServiceClient(cc grpc.ClientConnInterface) MessengerQueryServiceClient {
+	return &messengerQueryServiceClient{cc}
+}
+
+func (c *messengerQueryServiceClient) GetMessages(ctx context.Context, in *GetMessagesRequest, opts ...grpc.CallOption) (*GetMessagesResponse, error) {
+	out := new(GetMessagesResponse)
+	err := c.cc.Invoke(ctx, MessengerQueryService_GetMessages_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messengerQueryServiceClient) GetBlock(ctx context.Context, in *GetBlockRequest, opts ...grpc.CallOption) (*Block, error) {
+	out := new(Block)
+	err := c.cc.Invoke(ctx, MessengerQueryService_GetBlock_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messengerQueryServiceClient) GetTx(ctx context.Context, in *GetTxRequest, opts ...grpc.CallOption) (*StoredTransaction, error) {
+	out := new(StoredTransaction)
+	err := c.cc.Invoke(ctx, MessengerQueryService_GetTx_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *messengerQueryServiceClient) SubscribeMessages(ctx context.Context, in *SubscribeMessagesRequest, opts ...grpc.CallOption) (MessengerQueryService_SubscribeMessagesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &MessengerQueryService_ServiceDesc.Streams[0], MessengerQueryService_SubscribeMessages_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &messengerQueryServiceSubscribeMessagesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type MessengerQueryService_SubscribeMessagesClient interface {
+	Recv() (*SubscribeMessagesResponse, error)
+	grpc.ClientStream
+}
+
+type messengerQueryServiceSubscribeMessagesClient struct {
+	grpc.ClientStream
+}
+
+func (x *messengerQueryServiceSubscribeMessagesClient) Recv() (*SubscribeMessagesResponse, error) {
+	m := new(SubscribeMessagesResponse)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// MessengerQueryServiceServer is the server API for MessengerQueryService service.
+// All implementations must embed UnimplementedMessengerQueryServiceServer
+// for forward compatibility
+type MessengerQueryServiceServer interface {
+	// GetMessages returns a page of messages and replies.
+	GetMessages(context.Context, *GetMessagesRequest) (*GetMessagesResponse, error)
+	// GetBlock returns a block by height, or the latest block at a
+	// commitment level.
+	GetBlock(context.Context, *GetBlockRequest) (*Block, error)
+	// GetTx returns a transaction by id.
+	GetTx(context.Context, *GetTxRequest) (*StoredTransaction, error)
+	// SubscribeMessages streams the transactions of every block matching the
+	// filters once it reaches the commitment level.
+	SubscribeMessages(*SubscribeMessagesRequest, MessengerQueryService_SubscribeMessagesServer) error
+	mustEmbedUnimplementedMessengerQueryServiceServer()
+}
+
+// UnimplementedMessengerQueryServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedMessengerQueryServiceServer struct {
+}
+
+func (UnimplementedMessengerQueryServiceServer) GetMessages(context.Context, *GetMessagesRequest) (*GetMessagesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMessages not implemented")
+}
+func (UnimplementedMessengerQueryServiceServer) GetBlock(context.Context, *GetBlockRequest) (*Block, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlock not implemented")
+}
+func (UnimplementedMessengerQueryServiceServer) GetTx(context.Context, *GetTxRequest) (*StoredTransaction, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetTx not implemented")
+}
+func (UnimplementedMessengerQueryServiceServer) SubscribeMessages(*SubscribeMessagesRequest, MessengerQueryService_SubscribeMessagesServer) error {
+	return status.Errorf(codes.Unimplemented, "method SubscribeMessages not implemented")
+}
+func (UnimplementedMessengerQueryServiceServer) mustEmbedUnimplementedMessengerQueryServiceServer() {}
+
+// UnsafeMessengerQueryServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MessengerQueryServiceServer will
+// result in compilation errors.
+type UnsafeMessengerQueryServiceServer interface {
+	mustEmbedUnimplementedMessengerQueryServiceServer()
+}
+
+func RegisterMessengerQueryServiceServer(s grpc.ServiceRegistrar, srv MessengerQueryServiceServer) {
+	s.RegisterService(&MessengerQueryService_ServiceDesc, srv)
+}
+
+func _MessengerQueryService_GetMessages_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMessagesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessengerQueryServiceServer).GetMessages(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessengerQueryService_GetMessages_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessengerQueryServiceServer).GetMessages(ctx, req.(*GetMessagesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessengerQueryService_GetBlock_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBlockRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessengerQueryServiceServer).GetBlock(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessengerQueryService_GetBlock_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessengerQueryServiceServer).GetBlock(ctx, req.(*GetBlockRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessengerQueryService_GetTx_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetTxRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MessengerQueryServiceServer).GetTx(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MessengerQueryService_GetTx_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MessengerQueryServiceServer).GetTx(ctx, req.(*GetTxRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MessengerQueryService_SubscribeMessages_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeMessagesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MessengerQueryServiceServer).SubscribeMessages(m, &messengerQueryServiceSubscribeMessagesServer{stream})
+}
+
+type MessengerQueryService_SubscribeMessagesServer interface {
+	Send(*SubscribeMessagesResponse) error
+	grpc.ServerStream
+}
+
+type messengerQueryServiceSubscribeMessagesServer struct {
+	grpc.ServerStream
+}
+
+func (x *messengerQueryServiceSubscribeMessagesServer) Send(m *SubscribeMessagesResponse) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// MessengerQueryService_ServiceDesc is the grpc.ServiceDesc for MessengerQueryService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MessengerQueryService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "messenger.v1.MessengerQueryService",
+	HandlerType: (*MessengerQueryServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetMessages",
+			Handler:    _MessengerQueryService_GetMessages_Handler,
+		},
+		{
+			MethodName: "GetBlock",
+			Handler:    _MessengerQueryService_GetBlock_Handler,
+		},
+		{
+			MethodName: "GetTx",
+			Handler:    _MessengerQueryService_GetTx_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "SubscribeMessages",
+			Handler:       _MessengerQueryService_SubscribeMessages_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "messenger/v1/query.proto",
+}
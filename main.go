@@ -1,28 +1,420 @@
+// messenger-rollup runs a node of the messenger rollup.
 package main
 
 import (
 	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
+	sequencerClient "github.com/astriaorg/go-sequencer-client/client"
 	"github.com/astriaorg/messenger-rollup/messenger"
 	"github.com/sethvargo/go-envconfig"
 )
 
+// version is set at build time with -ldflags "-X main.version=..."
+var version = "dev"
+
+// command is a messenger-rollup subcommand.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(ctx context.Context, args []string) error
+}
+
+// commands is set in init, as the commands refer back to it for their usage.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"start", "start [-config FILE] [-KEY VALUE]...", "run the rollup node", runStart},
+		{"init", "init [-config FILE] [-force]", "write a config file with a new sequencer key", runInit},
+		{"keystore", "keystore -o FILE [-passphrase-file FILE] [-seed-file FILE] [-force]", "write a passphrase protected sequencer keystore", runKeystore},
+		{"genesis", "genesis -o FILE [-rollup-name NAME] [-time RFC3339] [-message SENDER:TEXT]... [-sequencer-height N] [-celestia-height N] [-celestia-variance N] [-force]", "write a genesis file", runGenesis},
+		{"version", "version", "print the version", runVersion},
+		{"config", "config show [-config FILE] [-format toml|json] [-KEY VALUE]...", "print the config the node would run with", runConfig},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: messenger-rollup COMMAND [ARGS]\n\ncommands:\n")
+	for _, cmd := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", cmd.name, cmd.summary)
+	}
+	fmt.Fprintf(os.Stderr, "\nconfig values are read, in order of precedence, from flags, environment\n"+
+		"variables and the config file. Without a command, the node is started.\n"+
+		"run messenger-rollup COMMAND -h for the arguments of a command\n")
+}
+
 func main() {
-	log.SetLevel(log.DebugLevel)
 	log.SetFormatter(&log.TextFormatter{})
 
-	// load env vars
-	var cfg messenger.Config
-	if err := envconfig.Process(context.Background(), &cfg); err != nil {
-		log.Fatal(err)
+	args := os.Args[1:]
+	// without a command, start the node as before subcommands existed
+	name := "start"
+	if len(args) > 0 && (len(args[0]) == 0 || args[0][0] != '-') {
+		name, args = args[0], args[1:]
+	} else if len(args) > 0 && (args[0] == "-h" || args[0] == "-help" || args[0] == "--help") {
+		usage()
+		os.Exit(2)
+	}
+
+	for _, cmd := range commands {
+		if cmd.name != name {
+			continue
+		}
+		if err := cmd.run(context.Background(), args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				os.Exit(2)
+			}
+			fmt.Fprintf(os.Stderr, "messenger-rollup %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
+
+// newFlagSet returns the flag set of a command, printing its usage line.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == name {
+				fmt.Fprintf(os.Stderr, "usage: messenger-rollup %s\n", cmd.usage)
+			}
+		}
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// setupLogging sets the log level and format from the config.
+func setupLogging(cfg messenger.Config) error {
+	level, err := log.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	log.SetLevel(level)
+	switch cfg.LogFormat {
+	case "json":
+		log.SetFormatter(&log.JSONFormatter{})
+	default:
+		log.SetFormatter(&log.TextFormatter{})
+	}
+	return nil
+}
+
+func runStart(ctx context.Context, args []string) error {
+	fs := newFlagSet("start")
+	flags := addConfigFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
 	}
-	log.Debugf("Read config from env: %+v\n", cfg)
+	cfg, err := flags.load(ctx)
+	if err != nil {
+		return err
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+	log.Infof("Starting %s", versionString())
+	log.Debugf("Read config: %s\n", configString(cfg))
 
 	// init from cfg
-	app := messenger.NewApp(cfg)
+	app, err := messenger.NewApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+
+	// run messenger until interrupted
+	return app.Run(ctx)
+}
+
+// initValues are the values of the required keys written by init, matching
+// the local setup of the README.
+var initValues = map[string]string{
+	"SEQUENCER_RPC": "http://localhost:26657",
+	"CONDUCTOR_RPC": "0.0.0.0:50051",
+	"COMPOSER_RPC":  "localhost:5053",
+	"RESTAPI_PORT":  ":8080",
+	"ROLLUP_NAME":   "messenger-rollup",
+}
+
+func runInit(ctx context.Context, args []string) error {
+	fs := newFlagSet("init")
+	path := fs.String("config", "messenger.toml", "config file to write")
+	force := fs.Bool("force", false, "overwrite an existing config file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
 
-	// run messenger
-	app.Run()
+	signer, err := sequencerClient.GenerateSigner()
+	if err != nil {
+		return err
+	}
+	seed := signer.Seed()
+	values := map[string]string{
+		"SEQUENCER_PRIVATE": hex.EncodeToString(seed[:]),
+	}
+	for k, v := range initValues {
+		values[k] = v
+	}
+	var cfg messenger.Config
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &cfg,
+		Lookuper: envconfig.MapLookuper(values),
+	}); err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	// the file holds the sequencer key
+	f, err := os.OpenFile(*path, flags, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", *path)
+		}
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "# messenger-rollup config, generated by %s\n", versionString())
+	fmt.Fprintf(f, "# keys may also be set with environment variables in upper case, or flags\n\n")
+	if err := writeConfigTOML(f, cfg, false); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	address := signer.Address()
+	fmt.Printf("wrote %s, sequencer address %s\n", *path, hex.EncodeToString(address[:]))
+	return nil
+}
+
+func runKeystore(ctx context.Context, args []string) error {
+	fs := newFlagSet("keystore")
+	path := fs.String("o", "", "keystore file to write")
+	passphraseFile := fs.String("passphrase-file", "", "file holding the passphrase, SEQUENCER_KEYSTORE_PASSPHRASE is used if empty")
+	seedFile := fs.String("seed-file", "", "file holding the hex seed of an existing key to encrypt, a new key is generated if empty")
+	force := fs.Bool("force", false, "overwrite an existing keystore")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	passphrase := os.Getenv("SEQUENCER_KEYSTORE_PASSPHRASE")
+	if *passphraseFile != "" {
+		data, err := os.ReadFile(*passphraseFile)
+		if err != nil {
+			return err
+		}
+		passphrase = strings.TrimRight(string(data), "\r\n")
+	}
+	if passphrase == "" {
+		return errors.New("no passphrase, set -passphrase-file or SEQUENCER_KEYSTORE_PASSPHRASE")
+	}
+
+	var seed []byte
+	if *seedFile != "" {
+		var err error
+		if seed, err = messenger.ReadSeedFile(*seedFile); err != nil {
+			return err
+		}
+	} else {
+		signer, err := sequencerClient.GenerateSigner()
+		if err != nil {
+			return err
+		}
+		generated := signer.Seed()
+		seed = generated[:]
+	}
+	data, err := messenger.EncryptKeystore(seed, passphrase)
+	if err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(*path, flags, 0o600)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", *path)
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	key, err := messenger.NewLocalKeyProvider(seed)
+	if err != nil {
+		return err
+	}
+	address := messenger.SequencerAddress(key.PublicKey())
+	fmt.Printf("wrote %s, sequencer address %s\n", *path, hex.EncodeToString(address[:]))
+	return nil
+}
+
+func runGenesis(ctx context.Context, args []string) error {
+	fs := newFlagSet("genesis")
+	path := fs.String("o", "", "genesis file to write")
+	rollupName := fs.String("rollup-name", os.Getenv("ROLLUP_NAME"), "rollup name, ROLLUP_NAME is used if empty")
+	genesisTime := fs.String("time", "", "RFC3339 genesis time, the current time if empty")
+	var messages []messenger.Transaction
+	fs.Func("message", "SENDER:TEXT message of the genesis block, may be repeated, a hello world message if none", func(value string) error {
+		sender, text, ok := strings.Cut(value, ":")
+		if !ok {
+			return errors.New("must be SENDER:TEXT")
+		}
+		messages = append(messages, messenger.Transaction{Sender: sender, Message: text})
+		return nil
+	})
+	sequencerHeight := fs.Uint("sequencer-height", 1, "first sequencer block height of the rollup")
+	celestiaHeight := fs.Uint("celestia-height", 1, "first Celestia block height of the rollup")
+	celestiaVariance := fs.Uint("celestia-variance", 1, "number of Celestia blocks a sequencer block may be found in")
+	force := fs.Bool("force", false, "overwrite an existing genesis file")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *path == "" {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	genesis := messenger.DefaultGenesis(*rollupName)
+	genesis.GenesisTime = time.Now().UTC().Truncate(time.Second)
+	if *genesisTime != "" {
+		t, err := time.Parse(time.RFC3339, *genesisTime)
+		if err != nil {
+			return fmt.Errorf("invalid -time: %w", err)
+		}
+		genesis.GenesisTime = t.UTC()
+	}
+	if len(messages) > 0 {
+		genesis.Messages = messages
+	}
+	genesis.SequencerGenesisBlockHeight = uint32(*sequencerHeight)
+	genesis.CelestiaBaseBlockHeight = uint32(*celestiaHeight)
+	genesis.CelestiaBlockVariance = uint32(*celestiaVariance)
+	if err := genesis.Validate(); err != nil {
+		return err
+	}
+	hash, err := genesis.Hash()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(genesis, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if *force {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(*path, flags, 0o644)
+	if err != nil {
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists, use -force to overwrite it", *path)
+		}
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s, genesis hash %s\n", *path, hex.EncodeToString(hash[:]))
+	return nil
+}
+
+// versionString returns the version along with the commit and Go version
+// the binary was built from.
+func versionString() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "messenger-rollup " + version
+	}
+	revision, modified := "", false
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			modified = setting.Value == "true"
+		}
+	}
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+	if revision != "" && modified {
+		revision += "-dirty"
+	}
+	if revision == "" {
+		revision = "unknown commit"
+	}
+	return fmt.Sprintf("messenger-rollup %s (%s, %s)", version, revision, info.GoVersion)
+}
+
+func runVersion(ctx context.Context, args []string) error {
+	fs := newFlagSet("version")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	fmt.Println(versionString())
+	return nil
+}
+
+func runConfig(ctx context.Context, args []string) error {
+	if len(args) == 0 || args[0] != "show" {
+		newFlagSet("config").Usage()
+		return flag.ErrHelp
+	}
+	fs := newFlagSet("config")
+	flags := addConfigFlags(fs)
+	format := fs.String("format", "toml", "output format: toml or json")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	cfg, err := flags.load(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch *format {
+	case "toml":
+		return writeConfigTOML(os.Stdout, cfg, true)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		return enc.Encode(configJSON(cfg, true))
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
 }
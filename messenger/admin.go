@@ -0,0 +1,247 @@
+package messenger
+
+import (
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// The admin API lets operators inspect and intervene in a running node. It
+// is served under /admin on the REST API and as the AdminService on the
+// gRPC server, both only if ADMIN_TOKEN is set, and every call must carry
+// the token as "Authorization: Bearer <token>".
+
+// BlockRef identifies a block.
+type BlockRef struct {
+	Height uint32 `json:"height"`
+	Hash   string `json:"hash"`
+}
+
+func blockRef(block Block) BlockRef {
+	return BlockRef{Height: block.Height, Hash: hex.EncodeToString(block.Hash[:])}
+}
+
+// CommitmentState is the latest block at each commitment level.
+type CommitmentState struct {
+	Executed BlockRef `json:"executed"`
+	Soft     BlockRef `json:"soft"`
+	Firm     BlockRef `json:"firm"`
+}
+
+// AdminStatus is the response of GET /admin/status.
+type AdminStatus struct {
+	Commitment  CommitmentState `json:"commitment"`
+	Storage     StorageStats    `json:"storage"`
+	Submissions SubmissionStats `json:"submissions"`
+}
+
+// RollbackResult is the response of POST /admin/rollback.
+type RollbackResult struct {
+	// Removed is the number of blocks removed
+	Removed int `json:"removed"`
+	// Disconnected is the number of event clients disconnected
+	Disconnected int             `json:"disconnected"`
+	Commitment   CommitmentState `json:"commitment"`
+}
+
+// adminBearer returns whether an Authorization header value carries the
+// admin token.
+func adminBearer(authorization string, token string) bool {
+	sent, ok := strings.CutPrefix(authorization, "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(sent), []byte(token)) == 1
+}
+
+// adminAuthMiddleware rejects requests without the admin token.
+func (a *App) adminAuthMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !adminBearer(r.Header.Get("Authorization"), a.adminToken) {
+			requestLog(r.Context()).Warnf("unauthorized admin request to %s\n", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// setupAdminRoutes adds the admin API routes, if an admin token is set.
+func (a *App) setupAdminRoutes() {
+	if a.adminToken == "" {
+		return
+	}
+	admin := a.restRouter.PathPrefix("/admin").Subrouter()
+	admin.Use(a.adminAuthMiddleware)
+	admin.HandleFunc("/status", a.getAdminStatus).Methods("GET")
+	admin.HandleFunc("/clients", a.getAdminClients).Methods("GET")
+	admin.HandleFunc("/clients/{id}", a.kickAdminClient).Methods("DELETE")
+	admin.HandleFunc("/log-level", a.getLogLevel).Methods("GET")
+	admin.HandleFunc("/log-level", a.setLogLevel).Methods("PUT")
+	admin.HandleFunc("/rollback", a.postRollback).Methods("POST")
+}
+
+// commitmentState returns the latest block at each commitment level.
+func (a *App) commitmentState() CommitmentState {
+	executed, soft, firm := a.rollupBlocks.CommitmentBlocks()
+	return CommitmentState{
+		Executed: blockRef(executed),
+		Soft:     blockRef(soft),
+		Firm:     blockRef(firm),
+	}
+}
+
+// adminStatus returns the commitment state, storage stats and composer
+// submissions.
+func (a *App) adminStatus() AdminStatus {
+	return AdminStatus{
+		Commitment:  a.commitmentState(),
+		Storage:     a.rollupBlocks.Stats(),
+		Submissions: a.sequencerClient.SubmissionStats(),
+	}
+}
+
+// adminClients returns the connected event clients ordered by ID.
+func (a *App) adminClients() []EventClientStats {
+	clients := a.eventHub.ClientStats()
+	sort.Slice(clients, func(i, j int) bool {
+		return clients[i].ID < clients[j].ID
+	})
+	return clients
+}
+
+// kickReason is the reason sent to clients disconnected by an admin, unless
+// one is given.
+const kickReason = "disconnected by admin"
+
+// kickClient disconnects an event client, returning false if it is not
+// connected.
+func (a *App) kickClient(id uint64, reason string) bool {
+	if reason == "" {
+		reason = kickReason
+	}
+	return a.eventHub.Disconnect(id, reason)
+}
+
+// setLogLevelName sets the log level of the node, returning the level.
+func setLogLevelName(name string) (log.Level, error) {
+	level, err := log.ParseLevel(name)
+	if err != nil {
+		return 0, err
+	}
+	log.SetLevel(level)
+	log.WithField("logLevel", level.String()).Warn("log level changed")
+	return level, nil
+}
+
+// rollback removes the executed and soft blocks above height. The event
+// clients are disconnected, as they may have received removed blocks, and
+// resume from their last height once the blocks are executed again.
+func (a *App) rollback(height uint32) (*RollbackResult, error) {
+	removed, err := a.rollupBlocks.Rollback(height)
+	if err != nil {
+		return nil, err
+	}
+	commitment := a.commitmentState()
+	blockHeight.WithLabelValues(string(CommitmentExecuted)).Set(float64(commitment.Executed.Height))
+	blockHeight.WithLabelValues(string(CommitmentSoft)).Set(float64(commitment.Soft.Height))
+	disconnected := a.eventHub.DisconnectAll(fmt.Sprintf("rolled back to height %d", height))
+	return &RollbackResult{
+		Removed:      removed,
+		Disconnected: disconnected,
+		Commitment:   commitment,
+	}, nil
+}
+
+// writeJSON responds with v as JSON.
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		requestLog(r.Context()).Errorf("error marshalling response: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+func (a *App) getAdminStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, a.adminStatus())
+}
+
+func (a *App) getAdminClients(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, a.adminClients())
+}
+
+// kick an event client, with an optional reason query parameter
+func (a *App) kickAdminClient(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		requestLog(r.Context()).Errorf("error parsing client id: %s\n", err)
+		http.Error(w, "invalid client id", http.StatusBadRequest)
+		return
+	}
+	if !a.kickClient(id, r.URL.Query().Get("reason")) {
+		http.Error(w, "client not connected", http.StatusNotFound)
+		return
+	}
+	requestLog(r.Context()).WithField(fieldClientID, id).Info("event client kicked by admin")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// logLevelBody is the body of the log level endpoints.
+type logLevelBody struct {
+	Level string `json:"level"`
+}
+
+func (a *App) getLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, logLevelBody{Level: log.GetLevel().String()})
+}
+
+func (a *App) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var body logLevelBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		requestLog(r.Context()).Errorf("error decoding log level: %s\n", err)
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	level, err := setLogLevelName(body.Level)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	writeJSON(w, r, logLevelBody{Level: level.String()})
+}
+
+// rollbackBody is the body of POST /admin/rollback.
+type rollbackBody struct {
+	// Height is the height of the last block kept
+	Height *uint32 `json:"height"`
+}
+
+func (a *App) postRollback(w http.ResponseWriter, r *http.Request) {
+	logger := requestLog(r.Context())
+	var body rollbackBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Height == nil {
+		logger.Errorf("error decoding rollback: %v\n", err)
+		http.Error(w, "invalid body, height required", http.StatusBadRequest)
+		return
+	}
+	res, err := a.rollback(*body.Height)
+	if err != nil {
+		logger.Errorf("error rolling back: %s\n", err)
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	logger.WithFields(log.Fields{
+		fieldHeight: *body.Height,
+		"removed":   res.Removed,
+	}).Warn("rolled back by admin")
+	writeJSON(w, r, res)
+}
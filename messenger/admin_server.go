@@ -0,0 +1,166 @@
+package messenger
+
+import (
+	"context"
+	"encoding/hex"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	messengerv1 "github.com/astriaorg/messenger-rollup/gen/messenger/v1"
+)
+
+// AdminServiceServer implements the AdminService, the gRPC counterpart of
+// the /admin REST routes.
+type AdminServiceServer struct {
+	messengerv1.UnimplementedAdminServiceServer
+	app *App
+}
+
+// NewAdminServiceServer creates a new AdminServiceServer.
+func NewAdminServiceServer(app *App) *AdminServiceServer {
+	return &AdminServiceServer{app: app}
+}
+
+// authorize checks the admin token sent in the authorization metadata.
+func (s *AdminServiceServer) authorize(ctx context.Context) error {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if adminBearer(value, s.app.adminToken) {
+			return nil
+		}
+	}
+	requestLog(ctx).Warn("unauthorized admin call")
+	return status.Error(codes.Unauthenticated, "admin token required")
+}
+
+func blockRefToPb(ref BlockRef) *messengerv1.BlockRef {
+	hash, _ := hex.DecodeString(ref.Hash)
+	return &messengerv1.BlockRef{Height: ref.Height, Hash: hash}
+}
+
+func commitmentStateToPb(state CommitmentState) *messengerv1.CommitmentState {
+	return &messengerv1.CommitmentState{
+		Executed: blockRefToPb(state.Executed),
+		Soft:     blockRefToPb(state.Soft),
+		Firm:     blockRefToPb(state.Firm),
+	}
+}
+
+// timestampToPb converts an optional time.
+func timestampToPb(t *time.Time) *timestamppb.Timestamp {
+	if t == nil {
+		return nil
+	}
+	return timestamppb.New(*t)
+}
+
+// GetStatus returns the commitment state, storage stats and composer
+// submissions.
+func (s *AdminServiceServer) GetStatus(ctx context.Context, req *messengerv1.GetStatusRequest) (*messengerv1.GetStatusResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	res := s.app.adminStatus()
+	storage, submissions := res.Storage, res.Submissions
+	return &messengerv1.GetStatusResponse{
+		Commitment: commitmentStateToPb(res.Commitment),
+		Storage: &messengerv1.StorageStats{
+			Blocks:      uint64(storage.Blocks),
+			TxBytes:     uint64(storage.TxBytes),
+			Txs:         uint64(storage.Txs),
+			Messages:    uint64(storage.Messages),
+			Senders:     uint64(storage.Senders),
+			Channels:    uint64(storage.Channels),
+			Reactions:   uint64(storage.Reactions),
+			SearchTerms: uint64(storage.SearchTerms),
+		},
+		Submissions: &messengerv1.SubmissionStats{
+			Composer:      submissions.Composer,
+			InFlight:      uint64(submissions.InFlight),
+			Succeeded:     submissions.Succeeded,
+			Failed:        submissions.Failed,
+			LastSuccessAt: timestampToPb(submissions.LastSuccessAt),
+			LastError:     submissions.LastError,
+			LastErrorAt:   timestampToPb(submissions.LastErrorAt),
+		},
+	}, nil
+}
+
+// ListClients returns the connected event clients.
+func (s *AdminServiceServer) ListClients(ctx context.Context, req *messengerv1.ListClientsRequest) (*messengerv1.ListClientsResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	res := &messengerv1.ListClientsResponse{Clients: []*messengerv1.EventClient{}}
+	for _, client := range s.app.adminClients() {
+		res.Clients = append(res.Clients, &messengerv1.EventClient{
+			Id:         client.ID,
+			Transport:  client.Transport,
+			RemoteAddr: client.RemoteAddr,
+			Commitment: commitmentToPb(client.Commitment),
+			Height:     client.Height,
+			Lag:        client.Lag,
+			Queued:     uint32(client.Queued),
+			CatchingUp: client.CatchingUp,
+		})
+	}
+	return res, nil
+}
+
+// KickClient disconnects an event client.
+func (s *AdminServiceServer) KickClient(ctx context.Context, req *messengerv1.KickClientRequest) (*messengerv1.KickClientResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	if !s.app.kickClient(req.Id, req.Reason) {
+		return nil, status.Errorf(codes.NotFound, "client %d not connected", req.Id)
+	}
+	requestLog(ctx).WithField(fieldClientID, req.Id).Info("event client kicked by admin")
+	return &messengerv1.KickClientResponse{}, nil
+}
+
+// GetLogLevel returns the log level.
+func (s *AdminServiceServer) GetLogLevel(ctx context.Context, req *messengerv1.GetLogLevelRequest) (*messengerv1.LogLevel, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	return &messengerv1.LogLevel{Level: log.GetLevel().String()}, nil
+}
+
+// SetLogLevel changes the log level.
+func (s *AdminServiceServer) SetLogLevel(ctx context.Context, req *messengerv1.LogLevel) (*messengerv1.LogLevel, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	level, err := setLogLevelName(req.Level)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &messengerv1.LogLevel{Level: level.String()}, nil
+}
+
+// Rollback removes the executed and soft blocks above a height.
+func (s *AdminServiceServer) Rollback(ctx context.Context, req *messengerv1.RollbackRequest) (*messengerv1.RollbackResponse, error) {
+	if err := s.authorize(ctx); err != nil {
+		return nil, err
+	}
+	res, err := s.app.rollback(req.Height)
+	if err != nil {
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	requestLog(ctx).WithFields(log.Fields{
+		fieldHeight: req.Height,
+		"removed":   res.Removed,
+	}).Warn("rolled back by admin")
+	return &messengerv1.RollbackResponse{
+		Removed:      uint32(res.Removed),
+		Disconnected: uint32(res.Disconnected),
+		Commitment:   commitmentStateToPb(res.Commitment),
+	}, nil
+}
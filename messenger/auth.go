@@ -0,0 +1,373 @@
+package messenger
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// API authentication is enabled by AUTH_MODE. Callers then send a static API
+// key, or a JWT signed with a shared HMAC secret or a private key whose
+// public key the node is given, as "Authorization: Bearer <credential>" or
+// "X-API-Key: <credential>". Every key and token carries scopes, checked per
+// endpoint: writes always require a scope, reads only if AUTH_READS is set.
+// The health, metrics, admin and execution APIs are not covered.
+
+// Auth modes of AUTH_MODE.
+const (
+	AuthModeNone   = "none"
+	AuthModeAPIKey = "apikey"
+	AuthModeHMAC   = "hmac"
+	AuthModeJWT    = "jwt"
+)
+
+// Scopes granted to API keys and tokens.
+const (
+	// ScopeRead allows reading messages, blocks and events
+	ScopeRead = "read"
+	// ScopeMessagesWrite allows submitting transactions
+	ScopeMessagesWrite = "messages:write"
+	// ScopeWebhooks allows managing webhooks
+	ScopeWebhooks = "webhooks"
+	// ScopeAll grants every scope
+	ScopeAll = "*"
+)
+
+var knownScopes = map[string]bool{
+	ScopeRead:          true,
+	ScopeMessagesWrite: true,
+	ScopeWebhooks:      true,
+	ScopeAll:           true,
+}
+
+// apiKeyHeader is the REST header and gRPC metadata key carrying an API key,
+// for callers that can't send an Authorization header.
+const apiKeyHeader = "X-API-Key"
+
+// accessTokenParam is the query parameter carrying a credential on GET
+// requests, as browsers can't set headers on WebSocket and EventSource
+// connections.
+const accessTokenParam = "access_token"
+
+// fieldPrincipal is the log field of the authenticated caller.
+const fieldPrincipal = "principal"
+
+var errInvalidCredential = errors.New("invalid credential")
+
+// Principal is an authenticated caller.
+type Principal struct {
+	Name   string
+	Scopes []string
+}
+
+// HasScope returns whether the caller was granted a scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope || s == ScopeAll {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator checks the credential sent by a caller.
+type Authenticator interface {
+	Authenticate(credential string) (*Principal, error)
+}
+
+// validateScopes checks that scopes are known.
+func validateScopes(scopes []string) error {
+	for _, scope := range scopes {
+		if !knownScopes[scope] {
+			return fmt.Errorf("unknown scope %q", scope)
+		}
+	}
+	return nil
+}
+
+// APIKey is a static API key and the scopes it grants.
+type APIKey struct {
+	// Name identifies the key in logs
+	Name   string   `json:"name"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+}
+
+// minAPIKeyLength is the shortest API key accepted.
+const minAPIKeyLength = 16
+
+// LoadAPIKeysFile reads a JSON list of API keys.
+func LoadAPIKeysFile(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []APIKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("invalid API keys file %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+// apiKeyAuthenticator authenticates static API keys. Keys are looked up by
+// their hash, so that the lookup time doesn't depend on how much of a key
+// matches.
+type apiKeyAuthenticator struct {
+	keys map[[sha256.Size]byte]*Principal
+}
+
+// NewAPIKeyAuthenticator returns an authenticator accepting the given keys.
+func NewAPIKeyAuthenticator(keys []APIKey) (Authenticator, error) {
+	auth := &apiKeyAuthenticator{keys: make(map[[sha256.Size]byte]*Principal, len(keys))}
+	names := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		if key.Name == "" {
+			return nil, errors.New("API key without a name")
+		}
+		if names[key.Name] {
+			return nil, fmt.Errorf("API key %s: duplicate name", key.Name)
+		}
+		names[key.Name] = true
+		if len(key.Key) < minAPIKeyLength {
+			return nil, fmt.Errorf("API key %s: must be at least %d characters", key.Name, minAPIKeyLength)
+		}
+		if err := validateScopes(key.Scopes); err != nil {
+			return nil, fmt.Errorf("API key %s: %w", key.Name, err)
+		}
+		hash := sha256.Sum256([]byte(key.Key))
+		if _, ok := auth.keys[hash]; ok {
+			return nil, fmt.Errorf("API key %s: duplicate key", key.Name)
+		}
+		auth.keys[hash] = &Principal{Name: key.Name, Scopes: key.Scopes}
+	}
+	return auth, nil
+}
+
+func (a *apiKeyAuthenticator) Authenticate(credential string) (*Principal, error) {
+	principal, ok := a.keys[sha256.Sum256([]byte(credential))]
+	if !ok {
+		return nil, errInvalidCredential
+	}
+	return principal, nil
+}
+
+// tokenAuthenticator authenticates JWTs. The caller is named by the sub
+// claim and granted the scopes of the scope claim, space separated, or of
+// the scopes claim, a list. Tokens must expire.
+type tokenAuthenticator struct {
+	key    interface{}
+	parser *jwt.Parser
+}
+
+// newTokenParser returns a parser of tokens signed with one of methods,
+// which requires exp and checks issuer and audience if not empty.
+func newTokenParser(methods []string, issuer string, audience string) *jwt.Parser {
+	opts := []jwt.ParserOption{jwt.WithValidMethods(methods), jwt.WithExpirationRequired()}
+	if issuer != "" {
+		opts = append(opts, jwt.WithIssuer(issuer))
+	}
+	if audience != "" {
+		opts = append(opts, jwt.WithAudience(audience))
+	}
+	return jwt.NewParser(opts...)
+}
+
+// NewHMACAuthenticator returns an authenticator accepting HS256, HS384 and
+// HS512 tokens signed with secret. Issuer and audience are checked if not
+// empty.
+func NewHMACAuthenticator(secret string, issuer string, audience string) Authenticator {
+	return &tokenAuthenticator{
+		key:    []byte(secret),
+		parser: newTokenParser([]string{"HS256", "HS384", "HS512"}, issuer, audience),
+	}
+}
+
+// NewJWTAuthenticator returns an authenticator accepting tokens signed with
+// the private key of a PEM encoded RSA, ECDSA or Ed25519 public key. Issuer
+// and audience are checked if not empty.
+func NewJWTAuthenticator(publicKeyPEM []byte, issuer string, audience string) (Authenticator, error) {
+	var key interface{}
+	var methods []string
+	if rsaKey, err := jwt.ParseRSAPublicKeyFromPEM(publicKeyPEM); err == nil {
+		key = rsaKey
+		methods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512"}
+	} else if ecKey, err := jwt.ParseECPublicKeyFromPEM(publicKeyPEM); err == nil {
+		key = ecKey
+		methods = []string{"ES256", "ES384", "ES512"}
+	} else if edKey, err := jwt.ParseEdPublicKeyFromPEM(publicKeyPEM); err == nil {
+		key = edKey
+		methods = []string{jwt.SigningMethodEdDSA.Alg()}
+	} else {
+		return nil, errors.New("not a PEM encoded RSA, ECDSA or Ed25519 public key")
+	}
+	return &tokenAuthenticator{key: key, parser: newTokenParser(methods, issuer, audience)}, nil
+}
+
+func (a *tokenAuthenticator) Authenticate(credential string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	_, err := a.parser.ParseWithClaims(credential, claims, func(*jwt.Token) (interface{}, error) {
+		return a.key, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", errInvalidCredential, err)
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", errInvalidCredential)
+	}
+	var scopes []string
+	switch v := claims["scopes"].(type) {
+	case []interface{}:
+		for _, scope := range v {
+			if s, ok := scope.(string); ok {
+				scopes = append(scopes, s)
+			}
+		}
+	default:
+		if scope, ok := claims["scope"].(string); ok {
+			scopes = strings.Fields(scope)
+		}
+	}
+	return &Principal{Name: subject, Scopes: scopes}, nil
+}
+
+// AuthConfig configures API authentication.
+type AuthConfig struct {
+	Mode          string
+	KeysFile      string
+	HMACSecret    string
+	PublicKeyFile string
+	Issuer        string
+	Audience      string
+}
+
+// newAuthenticator returns the authenticator of an auth mode, or nil if
+// authentication is disabled.
+func newAuthenticator(cfg AuthConfig) (Authenticator, error) {
+	switch cfg.Mode {
+	case AuthModeNone:
+		return nil, nil
+	case AuthModeAPIKey:
+		keys, err := LoadAPIKeysFile(cfg.KeysFile)
+		if err != nil {
+			return nil, err
+		}
+		return NewAPIKeyAuthenticator(keys)
+	case AuthModeHMAC:
+		return NewHMACAuthenticator(cfg.HMACSecret, cfg.Issuer, cfg.Audience), nil
+	case AuthModeJWT:
+		data, err := os.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		auth, err := NewJWTAuthenticator(data, cfg.Issuer, cfg.Audience)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", cfg.PublicKeyFile, err)
+		}
+		return auth, nil
+	default:
+		return nil, fmt.Errorf("unknown auth mode %q", cfg.Mode)
+	}
+}
+
+// bearerCredential returns the credential of an Authorization or API key
+// value, or "".
+func bearerCredential(authorization string, apiKey string) string {
+	if credential, ok := strings.CutPrefix(authorization, "Bearer "); ok {
+		return credential
+	}
+	return apiKey
+}
+
+// requestCredential returns the credential sent with a REST request.
+func requestCredential(r *http.Request) string {
+	credential := bearerCredential(r.Header.Get("Authorization"), r.Header.Get(apiKeyHeader))
+	if credential == "" && r.Method == http.MethodGet {
+		credential = r.URL.Query().Get(accessTokenParam)
+	}
+	return credential
+}
+
+// withScope wraps a REST handler to require a scope, if authentication is
+// enabled. Reads are only authenticated if AUTH_READS is set.
+func (a *App) withScope(scope string, next http.HandlerFunc) http.HandlerFunc {
+	if a.authenticator == nil || (scope == ScopeRead && !a.authReads) {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		logger := requestLog(r.Context())
+		principal, err := a.authenticator.Authenticate(requestCredential(r))
+		if err != nil {
+			logger.Warnf("unauthenticated request to %s: %s\n", r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="messenger"`)
+			http.Error(w, "invalid or missing credential", http.StatusUnauthorized)
+			return
+		}
+		logger = logger.WithField(fieldPrincipal, principal.Name)
+		if !principal.HasScope(scope) {
+			logger.Warnf("request to %s without scope %s\n", r.URL.Path, scope)
+			http.Error(w, fmt.Sprintf("scope %s required", scope), http.StatusForbidden)
+			return
+		}
+		next(w, r.WithContext(withLogger(r.Context(), logger)))
+	}
+}
+
+// queryServicePrefix is the method prefix of the gRPC query service, which
+// is authenticated like the REST reads.
+const queryServicePrefix = "/messenger.v1.MessengerQueryService/"
+
+// authorizeGRPC authenticates a gRPC call to the query service if reads are
+// authenticated, returning the context of the call with the caller logged.
+func (a *App) authorizeGRPC(ctx context.Context, method string) (context.Context, error) {
+	if a.authenticator == nil || !a.authReads || !strings.HasPrefix(method, queryServicePrefix) {
+		return ctx, nil
+	}
+	var authorization, apiKey string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+		if values := md.Get(apiKeyHeader); len(values) > 0 {
+			apiKey = values[0]
+		}
+	}
+	principal, err := a.authenticator.Authenticate(bearerCredential(authorization, apiKey))
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, "invalid or missing credential")
+	}
+	if !principal.HasScope(ScopeRead) {
+		return nil, status.Errorf(codes.PermissionDenied, "scope %s required", ScopeRead)
+	}
+	return withLogger(ctx, requestLog(ctx).WithField(fieldPrincipal, principal.Name)), nil
+}
+
+// unaryAuthInterceptor authenticates unary gRPC calls.
+func (a *App) unaryAuthInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authorizeGRPC(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// streamAuthInterceptor authenticates streaming gRPC calls.
+func (a *App) streamAuthInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authorizeGRPC(stream.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	return handler(srv, &loggerStream{ServerStream: stream, ctx: ctx})
+}
@@ -0,0 +1,63 @@
+package messenger
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+func TestHMACAuthenticatorChecksClaims(t *testing.T) {
+	const secret = "test-hmac-secret-0123456789abcdef"
+	auth := NewHMACAuthenticator(secret, "issuer", "messenger")
+	now := time.Now()
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":   "alice",
+			"iss":   "issuer",
+			"aud":   "messenger",
+			"exp":   now.Add(time.Minute).Unix(),
+			"scope": "read messages:write",
+		}
+	}
+	sign := func(claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	principal, err := auth.Authenticate(sign(valid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Name != "alice" || !principal.HasScope(ScopeMessagesWrite) {
+		t.Errorf("got principal %+v", principal)
+	}
+
+	for name, edit := range map[string]func(jwt.MapClaims){
+		"without exp":          func(c jwt.MapClaims) { delete(c, "exp") },
+		"expired":              func(c jwt.MapClaims) { c["exp"] = now.Add(-time.Minute).Unix() },
+		"not yet valid":        func(c jwt.MapClaims) { c["nbf"] = now.Add(time.Minute).Unix() },
+		"of another issuer":    func(c jwt.MapClaims) { c["iss"] = "other" },
+		"for another audience": func(c jwt.MapClaims) { c["aud"] = "other" },
+		"without sub":          func(c jwt.MapClaims) { delete(c, "sub") },
+	} {
+		claims := valid()
+		edit(claims)
+		if _, err := auth.Authenticate(sign(claims)); !errors.Is(err, errInvalidCredential) {
+			t.Errorf("token %s: got %v, want an invalid credential", name, err)
+		}
+	}
+
+	// tokens signed with another method are rejected
+	token, err := jwt.NewWithClaims(jwt.SigningMethodNone, valid()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Authenticate(token); !errors.Is(err, errInvalidCredential) {
+		t.Errorf("unsigned token: got %v, want an invalid credential", err)
+	}
+}
@@ -0,0 +1,349 @@
+// Package client is a Go client for the messenger rollup's REST and
+// WebSocket APIs.
+//
+//	c, err := client.New("http://localhost:8080")
+//	err = c.SendMessage(ctx, "alice", "general", "hello")
+//	err = c.Paginate(ctx, client.MessageQuery{Channel: "general"}, func(tx messenger.StoredTx) error {
+//		...
+//	})
+//	sub := c.Subscribe(ctx, client.SubscribeOptions{Channels: []string{"general"}})
+//	for event := range sub.Events() {
+//		...
+//	}
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/astriaorg/messenger-rollup/messenger"
+)
+
+// RequestEditor modifies every request before it is sent, for example to
+// add authentication headers. WebSocket handshakes go through it too.
+type RequestEditor func(req *http.Request) error
+
+// Client calls a messenger rollup node.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+	editors    []RequestEditor
+	// tlsConfig is used for WebSocket connections if set
+	tlsConfig *tls.Config
+	// powDifficulty is the proof of work solved before submitting
+	powDifficulty int
+}
+
+// Option configures a Client.
+type Option func(c *Client)
+
+// WithHTTPClient sets the HTTP client used for REST requests.
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithTLSConfig sets the TLS config of REST requests and WebSocket
+// connections, for example to trust a private CA or present a client
+// certificate. It replaces the client set by WithHTTPClient.
+func WithTLSConfig(tlsConfig *tls.Config) Option {
+	return func(c *Client) {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		c.httpClient = &http.Client{Transport: transport}
+		c.tlsConfig = tlsConfig
+	}
+}
+
+// WithRequestEditor adds a function modifying every request.
+func WithRequestEditor(editor RequestEditor) Option {
+	return func(c *Client) {
+		c.editors = append(c.editors, editor)
+	}
+}
+
+// WithToken sends an API key or token as a bearer credential with every
+// request, for nodes with authentication enabled.
+func WithToken(token string) Option {
+	return WithRequestEditor(func(req *http.Request) error {
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
+
+// WithPowDifficulty solves a proof of work of the given difficulty for
+// submitted transactions which don't carry a nonce. It must match the
+// rollup's POW_DIFFICULTY.
+func WithPowDifficulty(difficulty int) Option {
+	return func(c *Client) {
+		c.powDifficulty = difficulty
+	}
+}
+
+// New creates a client for the node serving the REST API at baseURL.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid base url: %w", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base url %q: scheme must be http or https", baseURL)
+	}
+	c := &Client{
+		baseURL:    u,
+		httpClient: http.DefaultClient,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c, nil
+}
+
+// APIError is a non 2xx response. Rule is set for rejected transactions.
+type APIError struct {
+	StatusCode int
+	Rule       string
+	Message    string
+	// RetryAfter is set on rate limited requests
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Rule != "" {
+		return fmt.Sprintf("%d %s: %s", e.StatusCode, e.Rule, e.Message)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("%d: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// newAPIError reads the error of a failed response.
+func newAPIError(resp *http.Response) *APIError {
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryAfter = time.Duration(secs) * time.Second
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	var ruleErr struct {
+		Error *messenger.TxRuleError `json:"error"`
+	}
+	if err := json.Unmarshal(body, &ruleErr); err == nil && ruleErr.Error != nil {
+		apiErr.Rule = ruleErr.Error.Rule
+		apiErr.Message = ruleErr.Error.Message
+	} else {
+		apiErr.Message = strings.TrimSpace(string(body))
+	}
+	return apiErr
+}
+
+// url returns the absolute url of an API path.
+func (c *Client) url(path string, params url.Values) string {
+	u := *c.baseURL
+	u.Path += path
+	u.RawQuery = params.Encode()
+	return u.String()
+}
+
+// do sends a request and decodes the JSON response into out, if not nil.
+func (c *Client) do(ctx context.Context, method string, path string, params url.Values, body interface{}, out interface{}) error {
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.url(path, params), reqBody)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for _, editor := range c.editors {
+		if err := editor(req); err != nil {
+			return err
+		}
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return newAPIError(resp)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// Submit sends a transaction to the rollup, solving its proof of work if
+// the client was configured with a difficulty. The transaction is included
+// once a block is executed, which Subscribe reports.
+func (c *Client) Submit(ctx context.Context, tx messenger.Transaction) error {
+	if c.powDifficulty > 0 && tx.PowNonce == 0 {
+		messenger.SolvePow(&tx, c.powDifficulty)
+	}
+	return c.do(ctx, http.MethodPost, "/message", nil, tx, nil)
+}
+
+// SendMessage submits a message to a channel, the default channel if empty.
+func (c *Client) SendMessage(ctx context.Context, sender string, channel string, message string) error {
+	return c.Submit(ctx, messenger.Transaction{
+		Sender:  sender,
+		Channel: channel,
+		Message: message,
+	})
+}
+
+// Reply submits a reply to a message or reply.
+func (c *Client) Reply(ctx context.Context, sender string, parentID string, message string) error {
+	return c.Submit(ctx, messenger.Transaction{
+		Type:     messenger.TxTypeReply,
+		Sender:   sender,
+		ParentID: parentID,
+		Message:  message,
+	})
+}
+
+// React submits a reaction to a message or reply.
+func (c *Client) React(ctx context.Context, sender string, parentID string, reaction string) error {
+	return c.Submit(ctx, messenger.Transaction{
+		Type:     messenger.TxTypeReaction,
+		Sender:   sender,
+		ParentID: parentID,
+		Reaction: reaction,
+	})
+}
+
+// Recent returns the 100 most recent messages and replies, oldest first.
+func (c *Client) Recent(ctx context.Context) ([]messenger.StoredTx, error) {
+	var messages []messenger.StoredTx
+	err := c.do(ctx, http.MethodGet, "/recent", nil, nil, &messages)
+	return messages, err
+}
+
+// MessageQuery selects a page of message history. Zero values use the
+// server defaults.
+type MessageQuery struct {
+	// Cursor is the NextCursor of the previous page
+	Cursor  string
+	Since   time.Time
+	Until   time.Time
+	Sender  string
+	Channel string
+	// Ascending returns the oldest messages first, newest first otherwise
+	Ascending bool
+	Limit     int
+}
+
+func (q *MessageQuery) params() url.Values {
+	params := url.Values{}
+	if q.Cursor != "" {
+		params.Set("cursor", q.Cursor)
+	}
+	if !q.Since.IsZero() {
+		params.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		params.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.Sender != "" {
+		params.Set("sender", q.Sender)
+	}
+	if q.Channel != "" {
+		params.Set("channel", q.Channel)
+	}
+	if q.Ascending {
+		params.Set("order", "asc")
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+	return params
+}
+
+// Messages returns a page of messages and replies.
+func (c *Client) Messages(ctx context.Context, q MessageQuery) (*messenger.MessagePage, error) {
+	var page messenger.MessagePage
+	if err := c.do(ctx, http.MethodGet, "/messages", q.params(), nil, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// Paginate calls fn for every message matching the query, fetching pages
+// until the last one or until fn returns an error, which is returned.
+func (c *Client) Paginate(ctx context.Context, q MessageQuery, fn func(tx messenger.StoredTx) error) error {
+	for {
+		page, err := c.Messages(ctx, q)
+		if err != nil {
+			return err
+		}
+		for _, tx := range page.Messages {
+			if err := fn(tx); err != nil {
+				return err
+			}
+		}
+		if page.NextCursor == "" {
+			return nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// Search returns up to limit messages containing every word of the query,
+// newest first. A zero limit uses the server default.
+func (c *Client) Search(ctx context.Context, query string, limit int) ([]messenger.SearchResult, error) {
+	params := url.Values{"q": {query}}
+	if limit > 0 {
+		params.Set("limit", strconv.Itoa(limit))
+	}
+	var res struct {
+		Results []messenger.SearchResult `json:"results"`
+	}
+	err := c.do(ctx, http.MethodGet, "/search", params, nil, &res)
+	return res.Results, err
+}
+
+// Thread returns the reply tree rooted at a message.
+func (c *Client) Thread(ctx context.Context, id string) (*messenger.ThreadNode, error) {
+	var thread messenger.ThreadNode
+	if err := c.do(ctx, http.MethodGet, "/messages/"+url.PathEscape(id)+"/thread", nil, nil, &thread); err != nil {
+		return nil, err
+	}
+	return &thread, nil
+}
+
+// Reactions returns the reaction counts of a message.
+func (c *Client) Reactions(ctx context.Context, id string) (map[string]int, error) {
+	var res struct {
+		Reactions map[string]int `json:"reactions"`
+	}
+	err := c.do(ctx, http.MethodGet, "/messages/"+url.PathEscape(id)+"/reactions", nil, nil, &res)
+	return res.Reactions, err
+}
+
+// Block returns the block at a height.
+func (c *Client) Block(ctx context.Context, height uint32) (*messenger.Block, error) {
+	var block messenger.Block
+	if err := c.do(ctx, http.MethodGet, "/block/"+strconv.FormatUint(uint64(height), 10), nil, nil, &block); err != nil {
+		return nil, err
+	}
+	return &block, nil
+}
@@ -0,0 +1,439 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"buf.build/gen/go/astria/astria/protocolbuffers/go/astria/sequencer/v1"
+	"buf.build/gen/go/astria/composer-apis/grpc/go/astria/composer/v1alpha1/composerv1alpha1grpc"
+	composerPb "buf.build/gen/go/astria/composer-apis/protocolbuffers/go/astria/composer/v1alpha1"
+	"buf.build/gen/go/astria/execution-apis/grpc/go/astria/execution/v1alpha2/executionv1alpha2grpc"
+	executionPb "buf.build/gen/go/astria/execution-apis/protocolbuffers/go/astria/execution/v1alpha2"
+	"github.com/sethvargo/go-envconfig"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/astriaorg/messenger-rollup/messenger"
+	"github.com/astriaorg/messenger-rollup/messenger/client"
+)
+
+const (
+	testAdminToken = "test-admin-token-0123456789"
+	testHMACSecret = "test-hmac-secret-0123456789abcdef"
+)
+
+// fakeComposer collects the transactions submitted by the node.
+type fakeComposer struct {
+	composerv1alpha1grpc.UnimplementedGrpcCollectorServiceServer
+
+	mu  sync.Mutex
+	txs [][]byte
+}
+
+func (c *fakeComposer) SubmitRollupTransaction(ctx context.Context, req *composerPb.SubmitRollupTransactionRequest) (*composerPb.SubmitRollupTransactionResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.txs = append(c.txs, req.Data)
+	return &composerPb.SubmitRollupTransactionResponse{}, nil
+}
+
+// take returns the transactions submitted since the last call.
+func (c *fakeComposer) take() [][]byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	txs := c.txs
+	c.txs = nil
+	return txs
+}
+
+// testNode is an in-process node, driven by the test in place of the
+// conductor.
+type testNode struct {
+	t        *testing.T
+	url      string
+	composer *fakeComposer
+	exec     executionv1alpha2grpc.ExecutionServiceClient
+	head     *executionPb.Block
+}
+
+// freeAddr returns a loopback address with a free port.
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// startNode runs a node with the default config overridden by env until the
+// test ends.
+func startNode(t *testing.T, env map[string]string) *testNode {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+
+	composer := &fakeComposer{}
+	composerLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	composerServer := grpc.NewServer()
+	composerv1alpha1grpc.RegisterGrpcCollectorServiceServer(composerServer, composer)
+	go composerServer.Serve(composerLis)
+	t.Cleanup(composerServer.Stop)
+
+	restAddr, conductorAddr := freeAddr(t), freeAddr(t)
+	values := map[string]string{
+		"SEQUENCER_RPC":                "http://127.0.0.1:1",
+		"CONDUCTOR_RPC":                conductorAddr,
+		"COMPOSER_RPC":                 composerLis.Addr().String(),
+		"RESTAPI_PORT":                 restAddr,
+		"ROLLUP_NAME":                  "test",
+		"SEQUENCER_PRIVATE":            strings.Repeat("1", 64),
+		"ADMIN_TOKEN":                  testAdminToken,
+		"RATE_LIMIT_IP_PER_MINUTE":     "0",
+		"RATE_LIMIT_SENDER_PER_MINUTE": "0",
+		"LOG_LEVEL":                    "warn",
+	}
+	for key, value := range env {
+		values[key] = value
+	}
+	var cfg messenger.Config
+	if err := envconfig.ProcessWith(ctx, &envconfig.Config{
+		Target:   &cfg,
+		Lookuper: envconfig.MapLookuper(values),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	app, err := messenger.NewApp(ctx, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- app.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("node stopped with error: %s", err)
+		}
+	})
+
+	url := "http://" + restAddr
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		resp, err := http.Get(url + "/healthz")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				break
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("node not healthy: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	// dial once the node listens, so that the first calls don't wait for a
+	// reconnection
+	conn, err := grpc.Dial(conductorAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	n := &testNode{
+		t:        t,
+		url:      url,
+		composer: composer,
+		exec:     executionv1alpha2grpc.NewExecutionServiceClient(conn),
+	}
+	state, err := n.exec.GetCommitmentState(ctx, &executionPb.GetCommitmentStateRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.head = state.Soft
+	return n
+}
+
+// produceBlock executes a block of the submitted transactions and soft and
+// firm commits it, returning its height.
+func (n *testNode) produceBlock() uint32 {
+	n.t.Helper()
+	ctx := context.Background()
+	var txs []*sequencerv1.RollupData
+	for _, tx := range n.composer.take() {
+		txs = append(txs, &sequencerv1.RollupData{
+			Value: &sequencerv1.RollupData_SequencedData{SequencedData: tx},
+		})
+	}
+	block, err := n.exec.ExecuteBlock(ctx, &executionPb.ExecuteBlockRequest{
+		PrevBlockHash: n.head.Hash,
+		Transactions:  txs,
+		Timestamp:     timestamppb.Now(),
+	})
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	if _, err := n.exec.UpdateCommitmentState(ctx, &executionPb.UpdateCommitmentStateRequest{
+		CommitmentState: &executionPb.CommitmentState{Soft: block, Firm: block},
+	}); err != nil {
+		n.t.Fatal(err)
+	}
+	n.head = block
+	return block.Number
+}
+
+// admin calls the admin API, decoding the response into out if set.
+func (n *testNode) admin(method string, path string, out interface{}) {
+	n.t.Helper()
+	req, err := http.NewRequest(method, n.url+"/admin"+path, nil)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+testAdminToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		n.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		n.t.Fatalf("%s %s: %s", method, path, resp.Status)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			n.t.Fatal(err)
+		}
+	}
+}
+
+// waitWSClient waits until a WebSocket client is subscribed at the soft
+// level with its events written, and returns its ID.
+func (n *testNode) waitWSClient() uint64 {
+	n.t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		var clients []messenger.EventClientStats
+		n.admin(http.MethodGet, "/clients", &clients)
+		for _, c := range clients {
+			if c.Transport == "ws" && c.Commitment == messenger.CommitmentSoft && c.Queued == 0 {
+				// leave the client time to read the written events
+				time.Sleep(100 * time.Millisecond)
+				return c.ID
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	n.t.Fatal("no subscribed WebSocket client")
+	return 0
+}
+
+func newClient(t *testing.T, n *testNode, opts ...client.Option) *client.Client {
+	t.Helper()
+	c, err := client.New(n.url, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSubmitAndPaginate(t *testing.T) {
+	n := startNode(t, nil)
+	c := newClient(t, n)
+	ctx := context.Background()
+
+	for i := 0; i < 7; i++ {
+		if err := c.SendMessage(ctx, "alice", "general", fmt.Sprintf("message %d", i)); err != nil {
+			t.Fatal(err)
+		}
+		if i%3 == 2 {
+			n.produceBlock()
+		}
+	}
+	if err := c.SendMessage(ctx, "bob", "general", "from bob"); err != nil {
+		t.Fatal(err)
+	}
+	n.produceBlock()
+
+	page, err := c.Messages(ctx, client.MessageQuery{Sender: "alice", Limit: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 3 || page.NextCursor == "" {
+		t.Fatalf("first page has %d messages, cursor %q", len(page.Messages), page.NextCursor)
+	}
+	if got := page.Messages[0].Message; got != "message 6" {
+		t.Errorf("newest message is %q", got)
+	}
+
+	var messages []string
+	err = c.Paginate(ctx, client.MessageQuery{Sender: "alice", Ascending: true, Limit: 3}, func(tx messenger.StoredTx) error {
+		messages = append(messages, tx.Message)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(messages) != 7 {
+		t.Fatalf("paginated %d messages, want 7: %q", len(messages), messages)
+	}
+	for i, message := range messages {
+		if want := fmt.Sprintf("message %d", i); message != want {
+			t.Errorf("message %d is %q, want %q", i, message, want)
+		}
+	}
+
+	stop := errors.New("stop")
+	count := 0
+	err = c.Paginate(ctx, client.MessageQuery{Limit: 2}, func(messenger.StoredTx) error {
+		count++
+		return stop
+	})
+	if !errors.Is(err, stop) || count != 1 {
+		t.Errorf("Paginate returned %v after %d messages", err, count)
+	}
+}
+
+func TestSubscribeReconnects(t *testing.T) {
+	n := startNode(t, nil)
+	c := newClient(t, n)
+	ctx := context.Background()
+
+	sub := c.Subscribe(ctx, client.SubscribeOptions{
+		Commitment:     messenger.CommitmentSoft,
+		ReconnectDelay: 200 * time.Millisecond,
+	})
+	defer sub.Close()
+
+	// the blocks produced while disconnected are replayed after
+	// reconnecting, even if the connection dropped before any block
+	next := uint32(1)
+	expect := func(count int) {
+		t.Helper()
+		for i := 0; i < count; i++ {
+			select {
+			case block, ok := <-sub.Events():
+				if !ok {
+					t.Fatalf("subscription ended: %v", sub.Err())
+				}
+				if block.Height != next {
+					t.Fatalf("received block %d, want %d", block.Height, next)
+				}
+				if len(block.Transactions) != 1 || block.Transactions[0].Message != fmt.Sprintf("block %d", next) {
+					t.Fatalf("block %d has transactions %+v", next, block.Transactions)
+				}
+				next++
+			case <-time.After(5 * time.Second):
+				t.Fatalf("block %d not received", next)
+			}
+		}
+	}
+	produce := func(count int) {
+		t.Helper()
+		for i := 0; i < count; i++ {
+			height := n.head.Number + 1
+			if err := c.SendMessage(ctx, "alice", "general", fmt.Sprintf("block %d", height)); err != nil {
+				t.Fatal(err)
+			}
+			n.produceBlock()
+		}
+	}
+
+	n.admin(http.MethodDelete, fmt.Sprintf("/clients/%d", n.waitWSClient()), nil)
+	produce(3)
+	expect(3)
+
+	n.admin(http.MethodDelete, fmt.Sprintf("/clients/%d", n.waitWSClient()), nil)
+	produce(2)
+	expect(2)
+
+	// then live blocks, without duplicates of the replayed ones
+	produce(1)
+	expect(1)
+	select {
+	case block := <-sub.Events():
+		t.Fatalf("unexpected block %d", block.Height)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestTokenSigner(t *testing.T) {
+	n := startNode(t, map[string]string{
+		"AUTH_MODE":        messenger.AuthModeHMAC,
+		"AUTH_HMAC_SECRET": testHMACSecret,
+	})
+	ctx := context.Background()
+
+	var apiErr *client.APIError
+	err := newClient(t, n).SendMessage(ctx, "alice", "general", "unsigned")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("unsigned submission returned %v, want 401", err)
+	}
+
+	signer, err := client.NewHMACSigner([]byte(testHMACSecret), client.TokenClaims{
+		Subject: "alice",
+		Scopes:  []string{messenger.ScopeMessagesWrite},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := newClient(t, n, client.WithTokenSigner(signer))
+	if err := c.SendMessage(ctx, "alice", "general", "signed"); err != nil {
+		t.Fatal(err)
+	}
+
+	wrong, err := client.NewHMACSigner([]byte(testHMACSecret), client.TokenClaims{
+		Subject: "alice",
+		Scopes:  []string{messenger.ScopeRead},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = newClient(t, n, client.WithTokenSigner(wrong)).SendMessage(ctx, "alice", "general", "read only")
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("submission without the write scope returned %v, want 403", err)
+	}
+}
+
+func TestUnauthenticatedSubmissionsDontUseSenderLimit(t *testing.T) {
+	n := startNode(t, map[string]string{
+		"AUTH_MODE":                    messenger.AuthModeHMAC,
+		"AUTH_HMAC_SECRET":             testHMACSecret,
+		"RATE_LIMIT_SENDER_PER_MINUTE": "1",
+		"RATE_LIMIT_SENDER_BURST":      "1",
+	})
+	ctx := context.Background()
+
+	var apiErr *client.APIError
+	unsigned := newClient(t, n)
+	for i := 0; i < 3; i++ {
+		err := unsigned.SendMessage(ctx, "alice", "general", "unsigned")
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized {
+			t.Fatalf("unsigned submission returned %v, want 401", err)
+		}
+	}
+
+	signer, err := client.NewHMACSigner([]byte(testHMACSecret), client.TokenClaims{
+		Subject: "alice",
+		Scopes:  []string{messenger.ScopeMessagesWrite},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newClient(t, n, client.WithTokenSigner(signer)).SendMessage(ctx, "alice", "general", "signed"); err != nil {
+		t.Fatalf("signed submission after unsigned ones: %s", err)
+	}
+}
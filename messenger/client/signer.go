@@ -0,0 +1,153 @@
+package client
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// defaultTokenTTL is the lifetime of signed tokens if TokenClaims doesn't
+// set one.
+const defaultTokenTTL = 5 * time.Minute
+
+// TokenClaims are the claims of the tokens a TokenSigner signs.
+type TokenClaims struct {
+	// Subject identifies the caller in the node's logs, it is required
+	Subject string
+	// Scopes are the scopes requested, such as messenger.ScopeMessagesWrite
+	Scopes []string
+	// Issuer and Audience must match the node's AUTH_JWT_ISSUER and
+	// AUTH_JWT_AUDIENCE if it sets them
+	Issuer   string
+	Audience string
+	// TTL is the lifetime of each token, 5 minutes if zero
+	TTL time.Duration
+}
+
+// TokenSigner signs short-lived JWTs authenticating requests to a node with
+// AUTH_MODE hmac or jwt. A token is reused until half its lifetime passed.
+type TokenSigner struct {
+	method jwt.SigningMethod
+	key    interface{}
+	claims TokenClaims
+
+	mu      sync.Mutex
+	token   string
+	renewAt time.Time
+}
+
+// NewHMACSigner returns a signer of HS256 tokens, for nodes with AUTH_MODE
+// hmac and the same AUTH_HMAC_SECRET.
+func NewHMACSigner(secret []byte, claims TokenClaims) (*TokenSigner, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("empty HMAC secret")
+	}
+	return newTokenSigner(jwt.SigningMethodHS256, secret, claims)
+}
+
+// NewKeySigner returns a signer of tokens signed with an RSA, ECDSA or
+// Ed25519 private key, for nodes with AUTH_MODE jwt and its public key.
+func NewKeySigner(key crypto.Signer, claims TokenClaims) (*TokenSigner, error) {
+	var method jwt.SigningMethod
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		method = jwt.SigningMethodRS256
+	case *ecdsa.PrivateKey:
+		switch k.Curve.Params().BitSize {
+		case 256:
+			method = jwt.SigningMethodES256
+		case 384:
+			method = jwt.SigningMethodES384
+		case 521:
+			method = jwt.SigningMethodES512
+		default:
+			return nil, fmt.Errorf("unsupported ECDSA curve %s", k.Curve.Params().Name)
+		}
+	case ed25519.PrivateKey:
+		method = jwt.SigningMethodEdDSA
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	return newTokenSigner(method, key, claims)
+}
+
+// ParsePrivateKeyPEM parses a PEM encoded RSA, ECDSA or Ed25519 private key
+// for NewKeySigner.
+func ParsePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	if key, err := jwt.ParseRSAPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPrivateKeyFromPEM(data); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseEdPrivateKeyFromPEM(data); err == nil {
+		if signer, ok := key.(crypto.Signer); ok {
+			return signer, nil
+		}
+	}
+	return nil, errors.New("not a PEM encoded RSA, ECDSA or Ed25519 private key")
+}
+
+func newTokenSigner(method jwt.SigningMethod, key interface{}, claims TokenClaims) (*TokenSigner, error) {
+	if claims.Subject == "" {
+		return nil, errors.New("token subject is required")
+	}
+	if claims.TTL <= 0 {
+		claims.TTL = defaultTokenTTL
+	}
+	return &TokenSigner{method: method, key: key, claims: claims}, nil
+}
+
+// Token returns a signed token, signing a new one if the last one is past
+// half its lifetime.
+func (s *TokenSigner) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	if s.token != "" && now.Before(s.renewAt) {
+		return s.token, nil
+	}
+
+	claims := jwt.MapClaims{
+		"sub": s.claims.Subject,
+		"iat": now.Unix(),
+		"exp": now.Add(s.claims.TTL).Unix(),
+	}
+	if len(s.claims.Scopes) > 0 {
+		claims["scope"] = strings.Join(s.claims.Scopes, " ")
+	}
+	if s.claims.Issuer != "" {
+		claims["iss"] = s.claims.Issuer
+	}
+	if s.claims.Audience != "" {
+		claims["aud"] = s.claims.Audience
+	}
+	token, err := jwt.NewWithClaims(s.method, claims).SignedString(s.key)
+	if err != nil {
+		return "", fmt.Errorf("error signing token: %w", err)
+	}
+	s.token, s.renewAt = token, now.Add(s.claims.TTL/2)
+	return token, nil
+}
+
+// WithTokenSigner authenticates every request, including WebSocket
+// handshakes, with a token from the signer.
+func WithTokenSigner(signer *TokenSigner) Option {
+	return WithRequestEditor(func(req *http.Request) error {
+		token, err := signer.Token()
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		return nil
+	})
+}
@@ -0,0 +1,322 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/astriaorg/messenger-rollup/messenger"
+)
+
+const (
+	defaultReconnectDelay    = time.Second
+	defaultMaxReconnectDelay = 30 * time.Second
+)
+
+// SubscribeOptions selects the transactions pushed to a subscription.
+type SubscribeOptions struct {
+	// Channels and Senders filter the transactions, empty matches all
+	Channels []string
+	Senders  []string
+	// Commitment defaults to executed
+	Commitment messenger.CommitmentLevel
+	// FromHeight replays the blocks from the height before live blocks.
+	// Without it only blocks above the height the server acknowledged the
+	// subscription at are pushed.
+	FromHeight *uint32
+	// ReconnectDelay is the delay before the first reconnection attempt,
+	// doubling up to MaxReconnectDelay
+	ReconnectDelay    time.Duration
+	MaxReconnectDelay time.Duration
+}
+
+// Tx is a transaction pushed to a subscription. Reactions carry the updated
+// reaction counts of the message they were added to.
+type Tx struct {
+	messenger.StoredTx
+	Reactions map[string]int `json:"reactions,omitempty"`
+}
+
+// BlockEvent is the matching transactions of a block.
+type BlockEvent struct {
+	Height       uint32
+	Hash         string
+	Commitment   messenger.CommitmentLevel
+	Transactions []Tx
+}
+
+// event is a WSEvent with its data left undecoded.
+type event struct {
+	Type       messenger.WSEventType     `json:"type"`
+	Height     uint32                    `json:"height"`
+	Hash       string                    `json:"hash"`
+	Commitment messenger.CommitmentLevel `json:"commitment"`
+	ID         string                    `json:"id"`
+	Data       json.RawMessage           `json:"data"`
+}
+
+// Subscription receives the blocks matching a subscription over WebSocket.
+// It reconnects when the connection drops and resumes after the last block
+// received, so no block is missed or received twice.
+type Subscription struct {
+	client *Client
+	opts   SubscribeOptions
+	events chan BlockEvent
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	// next is the height to resume from, set once known
+	next    uint32
+	hasNext bool
+
+	mu  sync.Mutex
+	err error
+}
+
+// Subscribe starts a subscription which runs until ctx is done or Close is
+// called.
+func (c *Client) Subscribe(ctx context.Context, opts SubscribeOptions) *Subscription {
+	if opts.ReconnectDelay <= 0 {
+		opts.ReconnectDelay = defaultReconnectDelay
+	}
+	if opts.MaxReconnectDelay <= 0 {
+		opts.MaxReconnectDelay = defaultMaxReconnectDelay
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s := &Subscription{
+		client: c,
+		opts:   opts,
+		events: make(chan BlockEvent, 100),
+		cancel: cancel,
+		done:   make(chan struct{}),
+	}
+	if opts.FromHeight != nil {
+		s.next, s.hasNext = *opts.FromHeight, true
+	}
+	go s.run(ctx)
+	return s
+}
+
+// Events returns the channel of received blocks. It is closed once the
+// subscription ends.
+func (s *Subscription) Events() <-chan BlockEvent {
+	return s.events
+}
+
+// Close ends the subscription.
+func (s *Subscription) Close() {
+	s.cancel()
+	<-s.done
+}
+
+// Err returns the last connection error.
+func (s *Subscription) Err() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+func (s *Subscription) setErr(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// run connects and reconnects with backoff until the context is done.
+func (s *Subscription) run(ctx context.Context) {
+	defer close(s.done)
+	defer close(s.events)
+
+	delay := s.opts.ReconnectDelay
+	for {
+		received, err := s.stream(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		s.setErr(err)
+		if received {
+			delay = s.opts.ReconnectDelay
+		}
+		log.WithField("next", s.next).Warnf("messenger subscription disconnected, reconnecting in %s: %v", delay, err)
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		delay = min(delay*2, s.opts.MaxReconnectDelay)
+	}
+}
+
+// dial opens the WebSocket connection, passing the handshake through the
+// client's request editors.
+func (s *Subscription) dial(ctx context.Context) (*websocket.Conn, error) {
+	u := *s.client.baseURL
+	u.Path += "/ws"
+	if u.Scheme == "https" {
+		u.Scheme = "wss"
+	} else {
+		u.Scheme = "ws"
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, editor := range s.client.editors {
+		if err := editor(req); err != nil {
+			return nil, err
+		}
+	}
+	dialer := *websocket.DefaultDialer
+	dialer.TLSClientConfig = s.client.tlsConfig
+	conn, resp, err := dialer.DialContext(ctx, u.String(), req.Header)
+	if err != nil {
+		if resp != nil {
+			return nil, newAPIError(resp)
+		}
+		return nil, err
+	}
+	return conn, nil
+}
+
+// stream runs one connection until it fails. It subscribes, resumes from the
+// next height if known, and pushes the received blocks. It reports whether
+// any event was received.
+func (s *Subscription) stream(ctx context.Context) (bool, error) {
+	conn, err := s.dial(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() {
+		conn.Close()
+	})
+	defer stop()
+
+	// the subscription must be acked before resuming, so that the replay
+	// is filtered and at the right commitment level
+	requests := []messenger.WSRequest{{
+		Type:       messenger.WSRequestSubscribe,
+		ID:         "subscribe",
+		Channels:   s.opts.Channels,
+		Senders:    s.opts.Senders,
+		Commitment: s.opts.Commitment,
+	}}
+	if s.hasNext {
+		next := s.next
+		requests = append(requests, messenger.WSRequest{
+			Type:       messenger.WSRequestResume,
+			ID:         "resume",
+			FromHeight: &next,
+		})
+	}
+	if err := conn.WriteJSON(requests[0]); err != nil {
+		return false, err
+	}
+	requests = requests[1:]
+
+	// when resuming, live blocks pushed before the resume ack are ignored
+	// as the replay covers them. Otherwise blocks are held back until the
+	// subscribe ack gives the height the subscription starts after, as the
+	// blocks pushed before it may not match the subscription
+	resumed := !s.hasNext
+	subscribed := false
+	var pending []event
+	received := false
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return received, err
+		}
+		received = true
+
+		var ev event
+		if err := json.Unmarshal(data, &ev); err != nil {
+			return received, fmt.Errorf("malformed event: %w", err)
+		}
+		switch ev.Type {
+		case messenger.WSEventAck:
+			if ev.ID == "subscribe" && len(requests) > 0 {
+				if err := conn.WriteJSON(requests[0]); err != nil {
+					return received, err
+				}
+				requests = requests[1:]
+			}
+			if ev.ID == "subscribe" && !subscribed {
+				subscribed = true
+				if !s.hasNext {
+					s.next, s.hasNext = ev.Height+1, true
+				}
+				// when resuming, the replay covers the pending blocks
+				for _, ev := range pending {
+					if !resumed {
+						break
+					}
+					if err := s.push(ctx, ev); err != nil {
+						return received, err
+					}
+				}
+				pending = nil
+			}
+			if ev.ID == "resume" {
+				resumed = true
+			}
+		case messenger.WSEventError:
+			var data struct {
+				Message string `json:"message"`
+			}
+			json.Unmarshal(ev.Data, &data)
+			if ev.ID != "" {
+				return received, fmt.Errorf("%s request failed: %s", ev.ID, data.Message)
+			}
+			log.Warnf("messenger subscription error: %s", data.Message)
+		case messenger.WSEventLive:
+			s.next, s.hasNext = max(s.next, ev.Height+1), true
+		case messenger.WSEventTransactions:
+			if !subscribed {
+				pending = append(pending, ev)
+				continue
+			}
+			if !resumed {
+				continue
+			}
+			if err := s.push(ctx, ev); err != nil {
+				return received, err
+			}
+		}
+	}
+}
+
+// push sends the block of a transactions event to Events, unless it is
+// below the next height.
+func (s *Subscription) push(ctx context.Context, ev event) error {
+	if ev.Height < s.next {
+		return nil
+	}
+	var txs []Tx
+	if err := json.Unmarshal(ev.Data, &txs); err != nil {
+		return fmt.Errorf("malformed transactions: %w", err)
+	}
+	block := BlockEvent{
+		Height:       ev.Height,
+		Hash:         ev.Hash,
+		Commitment:   ev.Commitment,
+		Transactions: txs,
+	}
+	select {
+	case s.events <- block:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	s.next = ev.Height + 1
+	return nil
+}
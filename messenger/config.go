@@ -0,0 +1,357 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Config is the configuration of a rollup node. Fields are read from the
+// environment variables named by their env tags, which the node binary also
+// accepts as config file keys and flags. Fields tagged secret are redacted
+// when the config is printed.
+type Config struct {
+	SequencerRPC string `env:"SEQUENCER_RPC, required"`
+	ConductorRPC string `env:"CONDUCTOR_RPC, required"`
+	ComposerRpc  string `env:"COMPOSER_RPC, required"`
+	RESTApiPort  string `env:"RESTAPI_PORT, required"`
+	RollupName   string `env:"ROLLUP_NAME, required"`
+	// GenesisFile is the JSON genesis of the rollup, see genesis.go. A
+	// single hello world message at the unix epoch is used if it is empty
+	GenesisFile string `env:"GENESIS_FILE"`
+
+	// the sequencer key, see keys.go. Exactly one of SeqPrivate, a hex seed,
+	// SeqKeyFile, SeqKeystoreFile and SeqSignerSocket must be set
+	SeqPrivate                string        `env:"SEQUENCER_PRIVATE" secret:"true"`
+	SeqKeyFile                string        `env:"SEQUENCER_KEY_FILE"`
+	SeqKeystoreFile           string        `env:"SEQUENCER_KEYSTORE_FILE"`
+	SeqKeystorePassphrase     string        `env:"SEQUENCER_KEYSTORE_PASSPHRASE" secret:"true"`
+	SeqKeystorePassphraseFile string        `env:"SEQUENCER_KEYSTORE_PASSPHRASE_FILE"`
+	SeqSignerSocket           string        `env:"SEQUENCER_SIGNER_SOCKET"`
+	SeqSignerTimeout          time.Duration `env:"SEQUENCER_SIGNER_TIMEOUT, default=5s"`
+
+	// LogLevel is a logrus level, LogFormat is text or json. Message
+	// contents are only logged at trace level
+	LogLevel  string `env:"LOG_LEVEL, default=info"`
+	LogFormat string `env:"LOG_FORMAT, default=text"`
+
+	// transaction limits, these must match across all nodes of the rollup
+	MaxMessageBytes  int `env:"MAX_MESSAGE_BYTES, default=1024"`
+	MaxSenderLength  int `env:"MAX_SENDER_LENGTH, default=64"`
+	MaxChannelLength int `env:"MAX_CHANNEL_LENGTH, default=32"`
+	MaxTxsPerBlock   int `env:"MAX_TXS_PER_BLOCK, default=1000"`
+	PowDifficulty    int `env:"POW_DIFFICULTY, default=0"`
+
+	// submission rate limits, a rate of 0 disables the limit
+	RateLimitIPPerMinute     int  `env:"RATE_LIMIT_IP_PER_MINUTE, default=60"`
+	RateLimitIPBurst         int  `env:"RATE_LIMIT_IP_BURST, default=10"`
+	RateLimitSenderPerMinute int  `env:"RATE_LIMIT_SENDER_PER_MINUTE, default=30"`
+	RateLimitSenderBurst     int  `env:"RATE_LIMIT_SENDER_BURST, default=5"`
+	RateLimitTrustProxy      bool `env:"RATE_LIMIT_TRUST_PROXY, default=false"`
+
+	// page sizes of the message history endpoint
+	MessagesDefaultLimit int `env:"MESSAGES_DEFAULT_LIMIT, default=50"`
+	MessagesMaxLimit     int `env:"MESSAGES_MAX_LIMIT, default=500"`
+
+	// ws, sse and poll clients lagging more blocks than WSMaxLag, or unable
+	// to take an event for WSSlowClientTimeout while catching up, are
+	// disconnected
+	WSMaxLag            uint32        `env:"WS_MAX_LAG, default=1000"`
+	WSSlowClientTimeout time.Duration `env:"WS_SLOW_CLIENT_TIMEOUT, default=30s"`
+
+	// webhooks registered at startup, as a JSON list of webhook configs.
+	// Hooks are only delivered to loopback, link-local and private addresses
+	// in WebhookAllowedNetworks, a list of CIDR networks
+	WebhooksFile           string        `env:"WEBHOOKS_FILE"`
+	WebhookTimeout         time.Duration `env:"WEBHOOK_TIMEOUT, default=10s"`
+	WebhookMaxAttempts     int           `env:"WEBHOOK_MAX_ATTEMPTS, default=5"`
+	WebhookAllowedNetworks []string      `env:"WEBHOOK_ALLOWED_NETWORKS"`
+
+	// readiness thresholds of /readyz, 0 disables a check
+	ReadyMaxExecuteAge time.Duration `env:"READY_MAX_EXECUTE_AGE, default=30s"`
+	ReadyMaxSoftLag    uint32        `env:"READY_MAX_SOFT_LAG, default=10"`
+	ReadyMaxFirmLag    uint32        `env:"READY_MAX_FIRM_LAG, default=0"`
+
+	// tracing, exported over OTLP gRPC if TracingExporter is otlp
+	TracingExporter    string  `env:"TRACING_EXPORTER, default=none"`
+	TracingEndpoint    string  `env:"TRACING_ENDPOINT"`
+	TracingInsecure    bool    `env:"TRACING_INSECURE, default=false"`
+	TracingSampleRatio float64 `env:"TRACING_SAMPLE_RATIO, default=1"`
+
+	// AdminToken enables the admin API, which requires it as a bearer token
+	AdminToken string `env:"ADMIN_TOKEN" secret:"true"`
+
+	// API authentication, see auth.go. AuthKeysFile is a JSON list of API
+	// keys, AuthHMACSecret verifies HMAC signed tokens and AuthJWTPublicKeyFile
+	// is the PEM public key verifying other tokens. AuthReads also requires
+	// the read scope for reads
+	AuthMode             string `env:"AUTH_MODE, default=none"`
+	AuthKeysFile         string `env:"AUTH_KEYS_FILE"`
+	AuthHMACSecret       string `env:"AUTH_HMAC_SECRET" secret:"true"`
+	AuthJWTPublicKeyFile string `env:"AUTH_JWT_PUBLIC_KEY_FILE"`
+	AuthJWTIssuer        string `env:"AUTH_JWT_ISSUER"`
+	AuthJWTAudience      string `env:"AUTH_JWT_AUDIENCE"`
+	AuthReads            bool   `env:"AUTH_READS, default=false"`
+
+	// CORSAllowedOrigins are the browser origins allowed to call the REST
+	// API and open WebSockets, * allows any
+	CORSAllowedOrigins []string `env:"CORS_ALLOWED_ORIGINS, default=*"`
+
+	// TLS, see tls.go. The REST and gRPC servers use TLS if TLSCertFile is
+	// set, and the gRPC server requires client certificates signed by
+	// TLSGRPCClientCAFile if it is set. The files are checked for changes
+	// every TLSReloadInterval
+	TLSCertFile         string        `env:"TLS_CERT_FILE"`
+	TLSKeyFile          string        `env:"TLS_KEY_FILE"`
+	TLSGRPCClientCAFile string        `env:"TLS_GRPC_CLIENT_CA_FILE"`
+	TLSReloadInterval   time.Duration `env:"TLS_RELOAD_INTERVAL, default=1m"`
+
+	// the composer is dialed over TLS if ComposerTLS is set, verified with
+	// ComposerTLSCAFile or the system roots, and presenting the client
+	// certificate of ComposerTLSCertFile if set
+	ComposerTLS           bool   `env:"COMPOSER_TLS, default=false"`
+	ComposerTLSCAFile     string `env:"COMPOSER_TLS_CA_FILE"`
+	ComposerTLSCertFile   string `env:"COMPOSER_TLS_CERT_FILE"`
+	ComposerTLSKeyFile    string `env:"COMPOSER_TLS_KEY_FILE"`
+	ComposerTLSServerName string `env:"COMPOSER_TLS_SERVER_NAME"`
+
+	// ShutdownTimeout bounds draining requests and streams on shutdown
+	ShutdownTimeout time.Duration `env:"SHUTDOWN_TIMEOUT, default=10s"`
+}
+
+// minAdminTokenLength is the shortest admin token accepted.
+const minAdminTokenLength = 16
+
+// minHMACSecretLength is the shortest token HMAC secret accepted.
+const minHMACSecretLength = 32
+
+// Validate checks the config before an app is created from it, returning all
+// invalid values at once.
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(name string, err error) {
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+	positive := func(name string, value int) {
+		if value <= 0 {
+			check(name, fmt.Errorf("must be positive, got %d", value))
+		}
+	}
+	nonNegative := func(name string, value int) {
+		if value < 0 {
+			check(name, fmt.Errorf("must not be negative, got %d", value))
+		}
+	}
+
+	check("SEQUENCER_RPC", validateURL(cfg.SequencerRPC))
+	check("CONDUCTOR_RPC", validateHostPort(cfg.ConductorRPC, false))
+	check("COMPOSER_RPC", validateHostPort(cfg.ComposerRpc, true))
+	check("RESTAPI_PORT", validateHostPort(cfg.RESTApiPort, false))
+	if cfg.RollupName == "" {
+		check("ROLLUP_NAME", errors.New("must not be empty"))
+	}
+	keySources := 0
+	for _, source := range []string{cfg.SeqPrivate, cfg.SeqKeyFile, cfg.SeqKeystoreFile, cfg.SeqSignerSocket} {
+		if source != "" {
+			keySources++
+		}
+	}
+	if keySources != 1 {
+		check("SEQUENCER_PRIVATE", fmt.Errorf("exactly one of SEQUENCER_PRIVATE, SEQUENCER_KEY_FILE, SEQUENCER_KEYSTORE_FILE and SEQUENCER_SIGNER_SOCKET must be set, got %d", keySources))
+	}
+	if cfg.SeqPrivate != "" {
+		check("SEQUENCER_PRIVATE", validateSeed(cfg.SeqPrivate))
+	}
+	if cfg.SeqKeystoreFile != "" && (cfg.SeqKeystorePassphrase == "") == (cfg.SeqKeystorePassphraseFile == "") {
+		check("SEQUENCER_KEYSTORE_FILE", errors.New("requires exactly one of SEQUENCER_KEYSTORE_PASSPHRASE and SEQUENCER_KEYSTORE_PASSPHRASE_FILE"))
+	}
+	if cfg.SeqSignerTimeout <= 0 {
+		check("SEQUENCER_SIGNER_TIMEOUT", errors.New("must be positive"))
+	}
+
+	if _, err := log.ParseLevel(cfg.LogLevel); err != nil {
+		check("LOG_LEVEL", err)
+	}
+	if cfg.LogFormat != "text" && cfg.LogFormat != "json" {
+		check("LOG_FORMAT", fmt.Errorf("must be text or json, got %q", cfg.LogFormat))
+	}
+
+	positive("MAX_MESSAGE_BYTES", cfg.MaxMessageBytes)
+	positive("MAX_SENDER_LENGTH", cfg.MaxSenderLength)
+	positive("MAX_CHANNEL_LENGTH", cfg.MaxChannelLength)
+	positive("MAX_TXS_PER_BLOCK", cfg.MaxTxsPerBlock)
+	if cfg.PowDifficulty < 0 || cfg.PowDifficulty > 256 {
+		check("POW_DIFFICULTY", fmt.Errorf("must be between 0 and 256, got %d", cfg.PowDifficulty))
+	}
+
+	nonNegative("RATE_LIMIT_IP_PER_MINUTE", cfg.RateLimitIPPerMinute)
+	nonNegative("RATE_LIMIT_IP_BURST", cfg.RateLimitIPBurst)
+	nonNegative("RATE_LIMIT_SENDER_PER_MINUTE", cfg.RateLimitSenderPerMinute)
+	nonNegative("RATE_LIMIT_SENDER_BURST", cfg.RateLimitSenderBurst)
+
+	positive("MESSAGES_DEFAULT_LIMIT", cfg.MessagesDefaultLimit)
+	positive("MESSAGES_MAX_LIMIT", cfg.MessagesMaxLimit)
+	if cfg.MessagesDefaultLimit > cfg.MessagesMaxLimit {
+		check("MESSAGES_DEFAULT_LIMIT", fmt.Errorf("must not exceed MESSAGES_MAX_LIMIT (%d)", cfg.MessagesMaxLimit))
+	}
+
+	if cfg.WSSlowClientTimeout <= 0 {
+		check("WS_SLOW_CLIENT_TIMEOUT", errors.New("must be positive"))
+	}
+	if cfg.WebhookTimeout <= 0 {
+		check("WEBHOOK_TIMEOUT", errors.New("must be positive"))
+	}
+	positive("WEBHOOK_MAX_ATTEMPTS", cfg.WebhookMaxAttempts)
+	if _, err := ParseNetworks(cfg.WebhookAllowedNetworks); err != nil {
+		check("WEBHOOK_ALLOWED_NETWORKS", err)
+	}
+	if cfg.ReadyMaxExecuteAge < 0 {
+		check("READY_MAX_EXECUTE_AGE", errors.New("must not be negative"))
+	}
+	if cfg.TracingExporter != "none" && cfg.TracingExporter != "otlp" {
+		check("TRACING_EXPORTER", fmt.Errorf("must be none or otlp, got %q", cfg.TracingExporter))
+	}
+	if cfg.TracingEndpoint != "" {
+		check("TRACING_ENDPOINT", validateHostPort(cfg.TracingEndpoint, true))
+	}
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		check("TRACING_SAMPLE_RATIO", fmt.Errorf("must be between 0 and 1, got %v", cfg.TracingSampleRatio))
+	}
+	if cfg.AdminToken != "" && len(cfg.AdminToken) < minAdminTokenLength {
+		check("ADMIN_TOKEN", fmt.Errorf("must be at least %d characters", minAdminTokenLength))
+	}
+	switch cfg.AuthMode {
+	case AuthModeNone:
+	case AuthModeAPIKey:
+		if cfg.AuthKeysFile == "" {
+			check("AUTH_KEYS_FILE", errors.New("required by AUTH_MODE apikey"))
+		}
+	case AuthModeHMAC:
+		if len(cfg.AuthHMACSecret) < minHMACSecretLength {
+			check("AUTH_HMAC_SECRET", fmt.Errorf("must be at least %d characters with AUTH_MODE hmac", minHMACSecretLength))
+		}
+	case AuthModeJWT:
+		if cfg.AuthJWTPublicKeyFile == "" {
+			check("AUTH_JWT_PUBLIC_KEY_FILE", errors.New("required by AUTH_MODE jwt"))
+		}
+	default:
+		check("AUTH_MODE", fmt.Errorf("must be none, apikey, hmac or jwt, got %q", cfg.AuthMode))
+	}
+	if len(cfg.CORSAllowedOrigins) == 0 {
+		check("CORS_ALLOWED_ORIGINS", errors.New("must not be empty"))
+	}
+	for _, origin := range cfg.CORSAllowedOrigins {
+		check("CORS_ALLOWED_ORIGINS", validateOrigin(origin))
+	}
+	if (cfg.TLSCertFile == "") != (cfg.TLSKeyFile == "") {
+		check("TLS_CERT_FILE", errors.New("must be set together with TLS_KEY_FILE"))
+	}
+	if cfg.TLSGRPCClientCAFile != "" && cfg.TLSCertFile == "" {
+		check("TLS_GRPC_CLIENT_CA_FILE", errors.New("requires TLS_CERT_FILE"))
+	}
+	if cfg.TLSReloadInterval <= 0 {
+		check("TLS_RELOAD_INTERVAL", errors.New("must be positive"))
+	}
+	if (cfg.ComposerTLSCertFile == "") != (cfg.ComposerTLSKeyFile == "") {
+		check("COMPOSER_TLS_CERT_FILE", errors.New("must be set together with COMPOSER_TLS_KEY_FILE"))
+	}
+	if !cfg.ComposerTLS && (cfg.ComposerTLSCAFile != "" || cfg.ComposerTLSCertFile != "" || cfg.ComposerTLSServerName != "") {
+		check("COMPOSER_TLS", errors.New("must be true to use the other COMPOSER_TLS settings"))
+	}
+	if cfg.ShutdownTimeout <= 0 {
+		check("SHUTDOWN_TIMEOUT", errors.New("must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+// validateURL checks an http or https url.
+func validateURL(value string) error {
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("must be an http or https url, got %q", value)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("missing host in %q", value)
+	}
+	return nil
+}
+
+// validateOrigin checks a CORS origin, which is *, or an http or https
+// origin whose host may contain one * wildcard.
+func validateOrigin(value string) error {
+	if value == "*" {
+		return nil
+	}
+	if strings.Count(value, "*") > 1 {
+		return fmt.Errorf("at most one wildcard allowed in %q", value)
+	}
+	u, err := url.Parse(strings.Replace(value, "*", "x", 1))
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+		return fmt.Errorf("must be an http or https origin, got %q", value)
+	}
+	return nil
+}
+
+// validateHostPort checks a host:port address. Listen addresses may omit the
+// host, addresses dialed may not.
+func validateHostPort(value string, needHost bool) error {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		return err
+	}
+	if needHost && host == "" {
+		return fmt.Errorf("missing host in %q", value)
+	}
+	if n, err := strconv.ParseUint(port, 10, 16); err != nil || (needHost && n == 0) {
+		return fmt.Errorf("invalid port in %q", value)
+	}
+	return nil
+}
+
+// validateSeed checks a hex encoded ed25519 seed.
+func validateSeed(value string) error {
+	_, err := parseSeed(value)
+	return err
+}
+
+// Redacted is the value printed in place of secrets.
+const Redacted = "<redacted>"
+
+// Redacted returns a copy of the config with the fields tagged secret
+// replaced by Redacted if set. The config is printed redacted everywhere,
+// by String and by the config command of the node binary.
+func (cfg Config) Redacted() Config {
+	v := reflect.ValueOf(&cfg).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := v.Field(i)
+		if t.Field(i).Tag.Get("secret") == "true" && field.Kind() == reflect.String && field.String() != "" {
+			field.SetString(Redacted)
+		}
+	}
+	return cfg
+}
+
+// String formats the config with the fields tagged secret redacted, so that
+// logging a config doesn't leak keys and tokens.
+func (cfg Config) String() string {
+	// plainConfig has no String method, to format the fields
+	type plainConfig Config
+	return fmt.Sprintf("%+v", plainConfig(cfg.Redacted()))
+}
@@ -0,0 +1,288 @@
+package messenger
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// eventTransport is the connection an event client's events are written to.
+type eventTransport interface {
+	// kind is the name of the transport: ws, sse, poll, webhook or grpc
+	kind() string
+	remoteAddr() string
+	// close closes the connection, passing the reason on to the client if
+	// it is not empty. It is safe to call more than once.
+	close(reason string)
+}
+
+// EventClient is a subscriber of the event hub. It filters the pushed blocks
+// by its subscription and queues them, along with other events, on egress,
+// which the transport writes to the connection.
+type EventClient struct {
+	// id is assigned by the hub on registration
+	id        uint64
+	transport eventTransport
+	hub       *EventHub
+	egress    chan WSEvent
+	// done is closed by the transport once it stops writing events
+	done chan struct{}
+	// seq is the sequence number of the last event written, only used by the
+	// transport's write loop
+	seq uint64
+
+	sub *subscription
+	// replaying is set while blocks are being replayed after a resume, live
+	// blocks are dropped meanwhile as the replay catches up to them
+	replaying bool
+	// nextHeight is the lowest block height not yet pushed at the subscribed
+	// commitment level, used to skip blocks already pushed by a replay
+	nextHeight uint32
+	subMu      sync.Mutex
+
+	disconnectOnce sync.Once
+	// slow is set if the client was disconnected for not keeping up
+	slow atomic.Bool
+}
+
+func NewEventClient(transport eventTransport, hub *EventHub) *EventClient {
+	return &EventClient{
+		transport: transport,
+		hub:       hub,
+		egress:    make(chan WSEvent, 50),
+		done:      make(chan struct{}),
+		sub:       newSubscription(),
+	}
+}
+
+// nextSeq assigns the next sequence number to an event about to be written.
+// Sequence numbers are assigned by the write loop so that they follow write
+// order.
+func (c *EventClient) nextSeq(event *WSEvent) {
+	c.seq++
+	event.Seq = c.seq
+}
+
+// subscribe adds channels and senders to the subscription and, if set,
+// replaces the commitment level.
+func (c *EventClient) subscribe(channels []string, senders []string, commitment CommitmentLevel) (WSSubscription, error) {
+	if commitment != "" && !commitment.valid() {
+		return WSSubscription{}, fmt.Errorf("unknown commitment level: %s", commitment)
+	}
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for _, channel := range channels {
+		c.sub.channels[channel] = true
+	}
+	for _, sender := range senders {
+		c.sub.senders[sender] = true
+	}
+	if commitment != "" && commitment != c.sub.commitment {
+		c.sub.commitment = commitment
+		c.nextHeight = 0
+	}
+	return c.sub.toWS(), nil
+}
+
+// unsubscribe removes channels and senders from the subscription.
+func (c *EventClient) unsubscribe(channels []string, senders []string) WSSubscription {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	for _, channel := range channels {
+		delete(c.sub.channels, channel)
+	}
+	for _, sender := range senders {
+		delete(c.sub.senders, sender)
+	}
+	return c.sub.toWS()
+}
+
+// send queues an event for the client, dropping it if the client is too
+// slow to keep up. Block events go through publishBlock instead, which
+// never drops them.
+func (c *EventClient) send(event WSEvent) {
+	if !c.trySend(event) {
+		log.WithField(fieldClientID, c.id).Warnf("Could not send %s event to client, egress full", event.Type)
+		eventsDropped.WithLabelValues(c.transport.kind(), string(event.Type)).Inc()
+	}
+}
+
+// trySend queues an event for the client if there is room in the queue.
+func (c *EventClient) trySend(event WSEvent) bool {
+	select {
+	case c.egress <- event:
+		return true
+	default:
+		return false
+	}
+}
+
+// sendBlocking queues an event for the client, waiting for room in the
+// queue. It returns false, disconnecting the client if it was too slow, if
+// the event could not be queued.
+func (c *EventClient) sendBlocking(event WSEvent) bool {
+	timer := time.NewTimer(c.hub.slowTimeout)
+	defer timer.Stop()
+	select {
+	case c.egress <- event:
+		return true
+	case <-timer.C:
+		c.disconnectSlow("client too slow")
+		return false
+	case <-c.done:
+		return false
+	}
+}
+
+// disconnect closes the transport giving the reason. The transport's loops
+// then exit and unregister the client.
+func (c *EventClient) disconnect(reason string) {
+	c.disconnectOnce.Do(func() {
+		log.WithField(fieldClientID, c.id).Warnf("disconnecting event client: %s", reason)
+		eventClientDisconnects.WithLabelValues(c.transport.kind()).Inc()
+		c.transport.close(reason)
+	})
+}
+
+// disconnectSlow disconnects a client which doesn't keep up with the blocks.
+func (c *EventClient) disconnectSlow(reason string) {
+	c.slow.Store(true)
+	c.disconnect(reason)
+}
+
+func (c *EventClient) sendError(id string, err error) {
+	c.send(WSEvent{Type: WSEventError, ID: id, Data: wsErrorData{Message: err.Error()}})
+}
+
+// publishBlock pushes the transactions of a block which reached the given
+// commitment level, if the client is subscribed to that level and any of
+// them match its filters. If the client's queue is full it starts catching
+// up from this block by replaying it from storage. It returns whether an
+// event was queued. It is only called by the hub and never blocks.
+func (c *EventClient) publishBlock(block *Block, txs []clientTx, level CommitmentLevel) bool {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if c.sub.commitment != level {
+		return false
+	}
+	if c.replaying {
+		if lag := block.Height - min(block.Height, c.nextHeight); lag > c.hub.maxLag {
+			go c.disconnectSlow(fmt.Sprintf("lagging %d blocks behind", lag))
+		}
+		return false
+	}
+	if block.Height < c.nextHeight {
+		return false
+	}
+
+	event, ok := c.blockEvent(block, txs, level)
+	if ok && !c.trySend(event) {
+		log.WithFields(log.Fields{
+			fieldClientID: c.id,
+			fieldHeight:   block.Height,
+		}).Info("event client queue full, catching up from storage")
+		eventClientCatchUps.WithLabelValues(c.transport.kind()).Inc()
+		c.replaying = true
+		go c.replay(block.Height)
+		return false
+	}
+	c.nextHeight = block.Height + 1
+	return ok
+}
+
+// blockEvent returns the transactions event for a block, filtered by the
+// subscription. It returns false if no transaction matches. The caller must
+// hold subMu.
+func (c *EventClient) blockEvent(block *Block, txs []clientTx, level CommitmentLevel) (WSEvent, bool) {
+	filtered := c.sub.filter(txs)
+	if len(filtered) == 0 {
+		return WSEvent{}, false
+	}
+	return WSEvent{
+		Type:       WSEventTransactions,
+		Height:     block.Height,
+		Hash:       hex.EncodeToString(block.Hash[:]),
+		Commitment: level,
+		Data:       filtered,
+	}, true
+}
+
+// stopLive stops pushing live blocks ahead of a replay.
+func (c *EventClient) stopLive() error {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+	if c.replaying {
+		return errors.New("already replaying")
+	}
+	c.replaying = true
+	return nil
+}
+
+// replay pushes all blocks from the given height up to the current height at
+// the subscribed level. Live blocks are dropped while replaying, so it loops
+// until no new block arrived, then switches to live mode while holding subMu
+// so that no block is pushed twice or missed. A height past the current one
+// resumes from the next block, so that the blocks up to it aren't dropped.
+func (c *EventClient) replay(fromHeight uint32) {
+	next := fromHeight
+	for {
+		c.subMu.Lock()
+		level := c.sub.commitment
+		target := c.hub.rollupBlocks.CommittedHeight(level)
+		if next > target {
+			next = target + 1
+			c.replaying = false
+			c.nextHeight = next
+			c.subMu.Unlock()
+			c.send(WSEvent{Type: WSEventLive, Height: next - 1, Commitment: level})
+			log.WithFields(log.Fields{fieldClientID: c.id, fieldHeight: next - 1}).Debug("event client replay caught up")
+			return
+		}
+		c.subMu.Unlock()
+
+		for ; next <= target; next++ {
+			block, err := c.hub.rollupBlocks.GetSingleBlock(next)
+			if err != nil {
+				log.Errorf("error getting block %d for replay: %s\n", next, err)
+				break
+			}
+			txs := blockClientTxs(*block, c.hub.rollupBlocks.State())
+			c.subMu.Lock()
+			event, ok := c.blockEvent(block, txs, level)
+			c.subMu.Unlock()
+			if ok && !c.sendBlocking(event) {
+				return
+			}
+			// record progress so that the lag can be reported
+			c.subMu.Lock()
+			c.nextHeight = next + 1
+			c.subMu.Unlock()
+		}
+	}
+}
+
+// stats returns the client's lag behind the chain at its subscribed level.
+func (c *EventClient) stats() EventClientStats {
+	c.subMu.Lock()
+	defer c.subMu.Unlock()
+
+	current := c.hub.rollupBlocks.CommittedHeight(c.sub.commitment)
+	stats := EventClientStats{
+		ID:         c.id,
+		Transport:  c.transport.kind(),
+		RemoteAddr: c.transport.remoteAddr(),
+		Commitment: c.sub.commitment,
+		Queued:     len(c.egress),
+		CatchingUp: c.replaying,
+	}
+	if c.nextHeight > 0 {
+		stats.Height = c.nextHeight - 1
+		stats.Lag = current - min(current, stats.Height)
+	}
+	return stats
+}
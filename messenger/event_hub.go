@@ -0,0 +1,301 @@
+package messenger
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// lagReportInterval is how often clients lagging behind are logged.
+const lagReportInterval = 30 * time.Second
+
+// reasonShutdown is the reason clients are disconnected with on shutdown.
+const reasonShutdown = "server shutting down"
+
+// EventClientStats describes a connected event client and how far it lags
+// behind.
+type EventClientStats struct {
+	ID uint64 `json:"id"`
+	// Transport is ws, sse, poll, webhook or grpc
+	Transport  string          `json:"transport"`
+	RemoteAddr string          `json:"remoteAddr"`
+	Commitment CommitmentLevel `json:"commitment"`
+	// Height is the last block height pushed to the client
+	Height uint32 `json:"height"`
+	// Lag is the number of blocks at the subscribed level not pushed yet
+	Lag uint32 `json:"lag"`
+	// Queued is the number of events waiting to be written
+	Queued     int  `json:"queued"`
+	CatchingUp bool `json:"catchingUp"`
+}
+
+// EventHub owns the set of connected event clients, whether they use the
+// WebSocket, Server-Sent Events or long-poll endpoints, webhooks or gRPC
+// streams, and fans executed blocks and commitment updates out to them.
+//
+// Pushing to a client never blocks the hub, so the hub always drains the
+// block channel. A client whose queue is full is switched to catching up:
+// it stops receiving live blocks and replays the missed blocks from storage
+// at its own pace instead. Clients lagging more than maxLag blocks, or whose
+// queue stays full for slowTimeout while catching up, are disconnected.
+type EventHub struct {
+	rollupBlocks  *RollupBlocks
+	blocks        <-chan Block
+	commitments   <-chan Commitment
+	register      chan *EventClient
+	unregister    chan *EventClient
+	statsReq      chan chan []EventClientStats
+	disconnectReq chan disconnectRequest
+	maxLag        uint32
+	slowTimeout   time.Duration
+	// quit is closed by Stop
+	quit     chan struct{}
+	stopOnce sync.Once
+	// closing is closed by CloseClients
+	closing   chan struct{}
+	closeOnce sync.Once
+	// nextID is the ID of the last client registered, IDs are assigned by
+	// Register so that the caller can read them
+	nextID atomic.Uint64
+
+	// only accessed by the run loop
+	clients   map[*EventClient]bool
+	committed Commitment
+}
+
+func NewEventHub(rollupBlocks *RollupBlocks, blocks <-chan Block, commitments <-chan Commitment, maxLag uint32, slowTimeout time.Duration) *EventHub {
+	return &EventHub{
+		rollupBlocks:  rollupBlocks,
+		blocks:        blocks,
+		commitments:   commitments,
+		register:      make(chan *EventClient),
+		unregister:    make(chan *EventClient),
+		statsReq:      make(chan chan []EventClientStats),
+		disconnectReq: make(chan disconnectRequest),
+		maxLag:        maxLag,
+		slowTimeout:   slowTimeout,
+		quit:          make(chan struct{}),
+		closing:       make(chan struct{}),
+		clients:       make(map[*EventClient]bool),
+	}
+}
+
+// Register adds a client to the hub. Once the hub is stopped, the client's
+// transport is closed instead.
+func (h *EventHub) Register(client *EventClient) {
+	client.id = h.nextID.Add(1)
+	select {
+	case h.register <- client:
+	case <-h.quit:
+		client.transport.close(reasonShutdown)
+	}
+}
+
+// RegisterFrom adds a client which first replays the blocks from fromHeight,
+// then receives live blocks. The client stops taking live blocks before it is
+// registered, so that none is pushed ahead of the replay and pushed again by
+// it; the replay switches it back to live blocks once caught up.
+func (h *EventHub) RegisterFrom(client *EventClient, fromHeight uint32) {
+	client.stopLive()
+	h.Register(client)
+	go client.replay(fromHeight)
+}
+
+// Unregister removes a client from the hub and closes its transport. It is
+// safe to call more than once.
+func (h *EventHub) Unregister(client *EventClient) {
+	select {
+	case h.unregister <- client:
+	case <-h.quit:
+		client.transport.close("")
+	}
+}
+
+// ClientStats returns the stats of every connected client.
+func (h *EventHub) ClientStats() []EventClientStats {
+	res := make(chan []EventClientStats)
+	select {
+	case h.statsReq <- res:
+		return <-res
+	case <-h.quit:
+		return []EventClientStats{}
+	}
+}
+
+// disconnectRequest asks the run loop to disconnect a client, or all clients
+// if id is 0, and to send back how many were disconnected.
+type disconnectRequest struct {
+	id     uint64
+	reason string
+	res    chan int
+}
+
+// Disconnect disconnects the client with the given ID, sending it the
+// reason. It returns false if there is no such client.
+func (h *EventHub) Disconnect(id uint64, reason string) bool {
+	return id != 0 && h.disconnect(id, reason) > 0
+}
+
+// DisconnectAll disconnects every client, sending them the reason, and
+// returns how many were connected.
+func (h *EventHub) DisconnectAll(reason string) int {
+	return h.disconnect(0, reason)
+}
+
+func (h *EventHub) disconnect(id uint64, reason string) int {
+	req := disconnectRequest{id: id, reason: reason, res: make(chan int)}
+	select {
+	case h.disconnectReq <- req:
+		return <-req.res
+	case <-h.quit:
+		return 0
+	}
+}
+
+// CloseClients disconnects every client and closes the transport of clients
+// registered afterwards, while Run keeps taking blocks until Stop. It is
+// called before the servers drain, so that long-lived streams don't hold up
+// the drain and blocks executed meanwhile don't wait for the hub. It returns
+// how many clients were connected.
+func (h *EventHub) CloseClients() int {
+	h.closeOnce.Do(func() {
+		close(h.closing)
+	})
+	return h.DisconnectAll(reasonShutdown)
+}
+
+// Stop makes Run disconnect all clients and return. It is safe to call more
+// than once.
+func (h *EventHub) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.quit)
+	})
+}
+
+// Run fans out blocks and commitment updates until the block channel closes
+// or the hub is stopped.
+func (h *EventHub) Run() {
+	ticker := time.NewTicker(lagReportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-h.quit:
+			for client := range h.clients {
+				client.transport.close(reasonShutdown)
+				eventClients.WithLabelValues(client.transport.kind()).Dec()
+			}
+			log.WithField("clients", len(h.clients)).Info("event hub stopped, clients disconnected")
+			h.clients = make(map[*EventClient]bool)
+			return
+		case client := <-h.register:
+			select {
+			case <-h.closing:
+				client.transport.close(reasonShutdown)
+				continue
+			default:
+			}
+			h.clients[client] = true
+			eventClients.WithLabelValues(client.transport.kind()).Inc()
+			log.WithFields(log.Fields{
+				fieldClientID: client.id,
+				"transport":   client.transport.kind(),
+			}).Debug("event client registered")
+		case client := <-h.unregister:
+			if _, ok := h.clients[client]; ok {
+				client.transport.close("")
+				delete(h.clients, client)
+				eventClients.WithLabelValues(client.transport.kind()).Dec()
+				log.WithField(fieldClientID, client.id).Debug("event client unregistered")
+			}
+		case block, ok := <-h.blocks:
+			if !ok {
+				return
+			}
+			h.publish(&block, CommitmentExecuted)
+		case commitment := <-h.commitments:
+			h.publishCommitted(h.committed.Soft, commitment.Soft, CommitmentSoft)
+			h.publishCommitted(h.committed.Firm, commitment.Firm, CommitmentFirm)
+			if commitment != h.committed {
+				for client := range h.clients {
+					client.send(WSEvent{Type: WSEventCommitment, Data: commitment})
+				}
+			}
+			h.committed = commitment
+		case res := <-h.statsReq:
+			res <- h.stats()
+		case req := <-h.disconnectReq:
+			n := 0
+			for client := range h.clients {
+				if req.id == 0 || client.id == req.id {
+					// the transport is closed outside the loop, the client
+					// unregisters once its connection ends
+					go client.disconnect(req.reason)
+					n++
+				}
+			}
+			req.res <- n
+		case <-ticker.C:
+			for _, stats := range h.stats() {
+				if stats.Lag > 0 {
+					log.WithFields(log.Fields{
+						fieldClientID: stats.ID,
+						"transport":   stats.Transport,
+						"lag":         stats.Lag,
+						"queued":      stats.Queued,
+						"catchingUp":  stats.CatchingUp,
+					}).Info("event client lagging behind")
+				}
+			}
+		}
+	}
+}
+
+// publish pushes a block which reached the given commitment level.
+func (h *EventHub) publish(block *Block, level CommitmentLevel) {
+	// only write blocks with transactions
+	txs := blockClientTxs(*block, h.rollupBlocks.State())
+	if len(txs) == 0 {
+		return
+	}
+	_, span := tracer.Start(context.Background(), "EventHub.publish", trace.WithAttributes(
+		attrHeight.Int64(int64(block.Height)),
+		attribute.String("messenger.commitment", string(level)),
+		attribute.Int("messenger.tx_count", len(txs)),
+		attribute.Int("messenger.clients", len(h.clients)),
+	))
+	defer span.End()
+	delivered := 0
+	for client := range h.clients {
+		if client.publishBlock(block, txs, level) {
+			delivered++
+		}
+	}
+	span.SetAttributes(attribute.Int("messenger.clients_delivered", delivered))
+}
+
+// publishCommitted pushes the blocks in (from, to] at the given commitment
+// level.
+func (h *EventHub) publishCommitted(from uint32, to uint32, level CommitmentLevel) {
+	for height := from + 1; height <= to; height++ {
+		block, err := h.rollupBlocks.GetSingleBlock(height)
+		if err != nil {
+			log.Errorf("error getting %s block %d: %s\n", level, height, err)
+			return
+		}
+		h.publish(block, level)
+	}
+}
+
+func (h *EventHub) stats() []EventClientStats {
+	stats := make([]EventClientStats, 0, len(h.clients))
+	for client := range h.clients {
+		stats = append(stats, client.stats())
+	}
+	return stats
+}
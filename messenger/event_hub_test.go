@@ -0,0 +1,135 @@
+package messenger
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// testTransport records the reason it was closed with.
+type testTransport struct {
+	closed chan string
+}
+
+func newTestTransport() *testTransport {
+	return &testTransport{closed: make(chan string, 1)}
+}
+
+func (t *testTransport) kind() string       { return "test" }
+func (t *testTransport) remoteAddr() string { return "test" }
+
+func (t *testTransport) close(reason string) {
+	select {
+	case t.closed <- reason:
+	default:
+	}
+}
+
+func TestEventHubTakesBlocksAfterClosingClients(t *testing.T) {
+	genesis, err := DefaultGenesis("test").Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := make(chan Block, 1)
+	rollupBlocks := NewRollupBlocks(genesis, blocks, make(chan Commitment, 1))
+	hub := NewEventHub(rollupBlocks, blocks, nil, 1000, time.Second)
+	go hub.Run()
+	defer hub.Stop()
+
+	connected := newTestTransport()
+	hub.Register(NewEventClient(connected, hub))
+	if n := hub.CloseClients(); n != 1 {
+		t.Fatalf("CloseClients disconnected %d clients, want 1", n)
+	}
+	select {
+	case reason := <-connected.closed:
+		if reason != reasonShutdown {
+			t.Errorf("client closed with %q, want %q", reason, reasonShutdown)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("connected client not closed")
+	}
+
+	late := newTestTransport()
+	hub.Register(NewEventClient(late, hub))
+	select {
+	case <-late.closed:
+	case <-time.After(time.Second):
+		t.Fatal("client registered after CloseClients not closed")
+	}
+
+	// blocks executed while the servers drain must not wait for the hub
+	added := make(chan error)
+	go func() {
+		parent := genesis
+		for i := 0; i < 10; i++ {
+			block := NewBlock(parent.Hash[:], rollupBlocks.Height(), nil, time.Now())
+			if err := rollupBlocks.AddBlock(block); err != nil {
+				added <- err
+				return
+			}
+			parent = block
+		}
+		added <- nil
+	}()
+	select {
+	case err := <-added:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("adding blocks blocked after CloseClients")
+	}
+	// the connected client stays registered until its connection ends
+	if stats := hub.ClientStats(); len(stats) != 1 {
+		t.Errorf("%d clients registered, want 1", len(stats))
+	}
+}
+
+// nextEvent returns the next event queued for a client.
+func nextEvent(t *testing.T, client *EventClient) WSEvent {
+	t.Helper()
+	select {
+	case event := <-client.egress:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event queued")
+		return WSEvent{}
+	}
+}
+
+func TestEventClientResumesPastHeadFromNextBlock(t *testing.T) {
+	genesis, err := DefaultGenesis("test").Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := make(chan Block, 1)
+	rollupBlocks := NewRollupBlocks(genesis, blocks, make(chan Commitment, 1))
+	hub := NewEventHub(rollupBlocks, blocks, nil, 1000, time.Second)
+	go hub.Run()
+	defer hub.Stop()
+
+	head := rollupBlocks.Height() - 1
+	client := NewEventClient(newTestTransport(), hub)
+	hub.RegisterFrom(client, head+10)
+	if event := nextEvent(t, client); event.Type != WSEventLive || event.Height != head {
+		t.Fatalf("got %s event at %d, want live at %d", event.Type, event.Height, head)
+	}
+
+	// the blocks between the head and the requested height are pushed
+	parent := genesis
+	for height := head + 1; height <= head+3; height++ {
+		tx, err := encodeTx(Transaction{Sender: "alice", Message: fmt.Sprintf("block %d", height)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		block := NewBlock(parent.Hash[:], rollupBlocks.Height(), [][]byte{tx}, time.Now())
+		if err := rollupBlocks.AddBlock(block); err != nil {
+			t.Fatal(err)
+		}
+		parent = block
+		if event := nextEvent(t, client); event.Type != WSEventTransactions || event.Height != height {
+			t.Fatalf("got %s event at %d, want transactions at %d", event.Type, event.Height, height)
+		}
+	}
+}
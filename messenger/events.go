@@ -0,0 +1,293 @@
+package messenger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Server-Sent Events and long-poll endpoints, for clients which can't use
+// WebSockets. Both register event clients with the same hub as /ws and
+// deliver the same WSEvent envelopes.
+//
+// GET /events streams events as SSE. The id of transactions and live events
+// is the block height, so a client reconnecting with the Last-Event-ID
+// header, as EventSource does, resumes right after the last block it saw.
+// fromHeight resumes from the given height on the first connection.
+//
+// GET /poll?after=N waits until blocks above height N have matching
+// transactions, or until the timeout, and returns their transactions events
+// along with the height to pass as after on the next poll.
+//
+// Both take the subscription as query parameters: channel and sender, which
+// may be repeated, and commitment.
+
+const (
+	// defaultPollTimeout and maxPollTimeout bound how long /poll waits for
+	// new blocks
+	defaultPollTimeout = 30 * time.Second
+	maxPollTimeout     = 60 * time.Second
+	// maxPollEvents is the maximum number of events returned by one poll
+	maxPollEvents = 100
+)
+
+// handlerTransport is the transport of event clients whose events are
+// written by a handler loop, such as SSE, long-poll, webhook and gRPC
+// stream clients. The loop returns once the transport is closed.
+type handlerTransport struct {
+	name   string
+	addr   string
+	closed chan struct{}
+	// reason is set before closed is closed
+	reason string
+	once   sync.Once
+}
+
+func newHandlerTransport(name string, addr string) *handlerTransport {
+	return &handlerTransport{
+		name:   name,
+		addr:   addr,
+		closed: make(chan struct{}),
+	}
+}
+
+func (t *handlerTransport) kind() string {
+	return t.name
+}
+
+func (t *handlerTransport) remoteAddr() string {
+	return t.addr
+}
+
+func (t *handlerTransport) close(reason string) {
+	t.once.Do(func() {
+		t.reason = reason
+		close(t.closed)
+	})
+}
+
+// PollResponse is the response of /poll. Next is the height to pass as after
+// on the next poll.
+type PollResponse struct {
+	Events []WSEvent `json:"events"`
+	Next   uint32    `json:"next"`
+}
+
+// parseEventSubscription subscribes an event client to the channels, senders
+// and commitment level of the request parameters.
+func parseEventSubscription(r *http.Request, client *EventClient) error {
+	params := r.URL.Query()
+	_, err := client.subscribe(params["channel"], params["sender"], CommitmentLevel(params.Get("commitment")))
+	return err
+}
+
+// parseHeight parses an optional block height parameter.
+func parseHeight(value string) (uint32, bool, error) {
+	if value == "" {
+		return 0, false, nil
+	}
+	height, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, false, err
+	}
+	return uint32(height), true, nil
+}
+
+// stream events as Server-Sent Events
+func (a *App) serveEvents(w http.ResponseWriter, r *http.Request) {
+	logger := requestLog(r.Context())
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		logger.Errorf("streaming not supported by response writer\n")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	// a reconnecting EventSource resumes after the last event it saw
+	fromHeight, resume, err := parseHeight(r.URL.Query().Get("fromHeight"))
+	if err != nil {
+		logger.Errorf("error parsing fromHeight: %s\n", err)
+		http.Error(w, "invalid fromHeight", http.StatusBadRequest)
+		return
+	}
+	if lastEventID := r.Header.Get("Last-Event-ID"); lastEventID != "" {
+		lastHeight, _, err := parseHeight(lastEventID)
+		if err != nil {
+			logger.Errorf("error parsing Last-Event-ID: %s\n", err)
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		fromHeight, resume = lastHeight+1, true
+	}
+
+	transport := newHandlerTransport("sse", r.RemoteAddr)
+	client := NewEventClient(transport, a.eventHub)
+	if err := parseEventSubscription(r, client); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// stop proxies from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	if resume {
+		a.eventHub.RegisterFrom(client, fromHeight)
+	} else {
+		a.eventHub.Register(client)
+	}
+	defer func() {
+		close(client.done)
+		a.eventHub.Unregister(client)
+	}()
+	logger.WithField(fieldClientID, client.id).Debug("new sse client connected")
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-client.egress:
+			client.nextSeq(&event)
+			if err := writeSSEEvent(w, event); err != nil {
+				logger.Errorf("error writing to sse client: %v", err)
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			// keep proxies from timing out idle streams
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-transport.closed:
+			if transport.reason != "" {
+				event := WSEvent{Type: WSEventError, Data: wsErrorData{Message: transport.reason}}
+				client.nextSeq(&event)
+				writeSSEEvent(w, event)
+				flusher.Flush()
+			}
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// writeSSEEvent writes an event in the text/event-stream format. Block
+// events carry their height as the event id.
+func writeSSEEvent(w http.ResponseWriter, event WSEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if event.Type == WSEventTransactions || event.Type == WSEventLive {
+		if _, err := fmt.Fprintf(w, "id: %d\n", event.Height); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data)
+	return err
+}
+
+// long-poll for the transactions of blocks above a height
+func (a *App) pollEvents(w http.ResponseWriter, r *http.Request) {
+	logger := requestLog(r.Context())
+	params := r.URL.Query()
+	after, ok, err := parseHeight(params.Get("after"))
+	if err != nil {
+		logger.Errorf("error parsing after: %s\n", err)
+		http.Error(w, "invalid after", http.StatusBadRequest)
+		return
+	}
+
+	timeout := defaultPollTimeout
+	if timeoutStr := params.Get("timeout"); timeoutStr != "" {
+		secs, err := strconv.Atoi(timeoutStr)
+		if err != nil || secs < 0 {
+			http.Error(w, "timeout must be a number of seconds", http.StatusBadRequest)
+			return
+		}
+		timeout = min(time.Duration(secs)*time.Second, maxPollTimeout)
+	}
+
+	transport := newHandlerTransport("poll", r.RemoteAddr)
+	client := NewEventClient(transport, a.eventHub)
+	if err := parseEventSubscription(r, client); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if !ok {
+		// without after, wait for the next block
+		after = a.rollupBlocks.CommittedHeight(client.sub.commitment)
+	}
+
+	a.eventHub.RegisterFrom(client, after+1)
+	defer func() {
+		close(client.done)
+		a.eventHub.Unregister(client)
+	}()
+
+	res, err := collectPollEvents(r, client, transport, after, timeout)
+	if err != nil {
+		logger.Errorf("error polling events: %s\n", err)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	resJson, err := json.Marshal(res)
+	if err != nil {
+		logger.Errorf("error marshalling poll response: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(resJson)
+}
+
+// collectPollEvents reads the transactions events of a poll client. It
+// returns once the replay caught up with at least one event, or once
+// maxPollEvents events or the timeout were reached.
+func collectPollEvents(r *http.Request, client *EventClient, transport *handlerTransport, after uint32, timeout time.Duration) (*PollResponse, error) {
+	res := &PollResponse{Events: []WSEvent{}, Next: after}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	caughtUp := false
+	for {
+		select {
+		case event := <-client.egress:
+			switch event.Type {
+			case WSEventTransactions:
+				client.nextSeq(&event)
+				res.Events = append(res.Events, event)
+				res.Next = event.Height
+				if caughtUp || len(res.Events) == maxPollEvents {
+					return res, nil
+				}
+			case WSEventLive:
+				caughtUp = true
+				res.Next = max(res.Next, event.Height)
+				if len(res.Events) > 0 {
+					return res, nil
+				}
+			}
+		case <-timer.C:
+			return res, nil
+		case <-transport.closed:
+			if transport.reason != "" {
+				return nil, errors.New(transport.reason)
+			}
+			return res, nil
+		case <-r.Context().Done():
+			return res, nil
+		}
+	}
+}
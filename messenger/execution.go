@@ -5,8 +5,11 @@ import (
 	"context"
 	"encoding/hex"
 	"errors"
+	"sync/atomic"
+	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
 
 	astriaGrpc "buf.build/gen/go/astria/execution-apis/grpc/go/astria/execution/v1alpha2/executionv1alpha2grpc"
 	astriaPb "buf.build/gen/go/astria/execution-apis/protocolbuffers/go/astria/execution/v1alpha2"
@@ -17,37 +20,50 @@ type ExecutionServiceServerV1Alpha2 struct {
 	astriaGrpc.UnimplementedExecutionServiceServer
 	rollupBlocks *RollupBlocks
 	rollupID     []byte
+	genesis      *Genesis
+	txLimits     TxLimits
+	// lastExecuteBlock is the unix nano time ExecuteBlock was last called
+	lastExecuteBlock atomic.Int64
 }
 
 // NewExecutionServiceServerV1Alpha2 creates a new ExecutionServiceServerV1Alpha2.
-func NewExecutionServiceServerV1Alpha2(rollupBlocks *RollupBlocks, rollupID []byte) *ExecutionServiceServerV1Alpha2 {
+func NewExecutionServiceServerV1Alpha2(rollupBlocks *RollupBlocks, rollupID []byte, genesis *Genesis, txLimits TxLimits) *ExecutionServiceServerV1Alpha2 {
 	return &ExecutionServiceServerV1Alpha2{
 		rollupBlocks: rollupBlocks,
 		rollupID:     rollupID,
+		genesis:      genesis,
+		txLimits:     txLimits,
 	}
 }
 
 func (s *ExecutionServiceServerV1Alpha2) GetGenesisInfo(ctx context.Context, req *astriaPb.GetGenesisInfoRequest) (*astriaPb.GenesisInfo, error) {
-	log.Debug("GetGenesisInfo called")
+	ctx, span := tracer.Start(ctx, "ExecutionService.GetGenesisInfo")
+	defer span.End()
+	logger := requestLog(ctx)
+	logger.Debug("GetGenesisInfo called")
 	res := &astriaPb.GenesisInfo{
 		RollupId:                    s.rollupID,
-		SequencerGenesisBlockHeight: uint32(1),
-		CelestiaBaseBlockHeight:     uint32(1),
-		CelestiaBlockVariance:       uint32(1),
+		SequencerGenesisBlockHeight: s.genesis.SequencerGenesisBlockHeight,
+		CelestiaBaseBlockHeight:     s.genesis.CelestiaBaseBlockHeight,
+		CelestiaBlockVariance:       s.genesis.CelestiaBlockVariance,
 	}
-	log.WithFields(log.Fields{
+	logger.WithFields(log.Fields{
 		"rollupId": hex.EncodeToString(res.RollupId),
 	}).Debug("GetGenesisInfo completed")
 	return res, nil
 }
 
 // GetBlock retrieves a block by its identifier.
-func (s *ExecutionServiceServerV1Alpha2) GetBlock(ctx context.Context, req *astriaPb.GetBlockRequest) (*astriaPb.Block, error) {
-	log.WithField(
+func (s *ExecutionServiceServerV1Alpha2) GetBlock(ctx context.Context, req *astriaPb.GetBlockRequest) (_ *astriaPb.Block, err error) {
+	ctx, span := tracer.Start(ctx, "ExecutionService.GetBlock")
+	defer func() { endSpan(span, err) }()
+	logger := requestLog(ctx)
+	logger.WithField(
 		"identifier", req.Identifier,
 	).Debug("GetBlock called")
 	switch req.Identifier.Identifier.(type) {
 	case *astriaPb.BlockIdentifier_BlockNumber:
+		span.SetAttributes(attrHeight.Int64(int64(req.Identifier.GetBlockNumber())))
 		block, err := s.rollupBlocks.GetSingleBlock(uint32(req.Identifier.GetBlockNumber()))
 		if err != nil {
 			return nil, err
@@ -56,20 +72,29 @@ func (s *ExecutionServiceServerV1Alpha2) GetBlock(ctx context.Context, req *astr
 		if err != nil {
 			return nil, err
 		}
+		span.SetAttributes(attrBlockHash.String(hex.EncodeToString(block.Hash[:])))
 
-		log.WithField(
-			"blockHash", hex.EncodeToString(block.Hash[:]),
-		).Debugf("GetBlock completed with response: %v\n", block)
+		// blocks hold the message contents, which are only logged at trace
+		// level
+		logger.WithFields(log.Fields{
+			fieldHeight: block.Height,
+			fieldHash:   hex.EncodeToString(block.Hash[:]),
+		}).Debug("GetBlock completed")
+		logger.Tracef("GetBlock response: %v", block)
 		return blockPb, nil
 	default:
-		log.Debugf("GetBlock completed with error: invalid identifier: %v\n", req.Identifier)
+		logger.Debugf("GetBlock completed with error: invalid identifier: %v\n", req.Identifier)
 		return nil, errors.New("invalid identifier")
 	}
 }
 
 // BatchGetBlocks retrieves multiple blocks by their identifiers.
-func (s *ExecutionServiceServerV1Alpha2) BatchGetBlocks(ctx context.Context, req *astriaPb.BatchGetBlocksRequest) (*astriaPb.BatchGetBlocksResponse, error) {
-	log.WithField(
+func (s *ExecutionServiceServerV1Alpha2) BatchGetBlocks(ctx context.Context, req *astriaPb.BatchGetBlocksRequest) (_ *astriaPb.BatchGetBlocksResponse, err error) {
+	ctx, span := tracer.Start(ctx, "ExecutionService.BatchGetBlocks")
+	span.SetAttributes(attribute.Int("messenger.block_count", len(req.Identifiers)))
+	defer func() { endSpan(span, err) }()
+	logger := requestLog(ctx)
+	logger.WithField(
 		"identifiers", req.Identifiers,
 	).Debug("BatchGetBlocks called")
 	res := &astriaPb.BatchGetBlocksResponse{
@@ -91,31 +116,65 @@ func (s *ExecutionServiceServerV1Alpha2) BatchGetBlocks(ctx context.Context, req
 		}
 	}
 
-	log.Debugf("BatchGetBlocks completed with response: %v\n", res)
+	logger.WithField("blocks", len(res.Blocks)).Debug("BatchGetBlocks completed")
+	logger.Tracef("BatchGetBlocks response: %v", res)
 	return res, nil
 }
 
 // ExecuteBlock executes a block and adds it to the blockchain.
-func (s *ExecutionServiceServerV1Alpha2) ExecuteBlock(ctx context.Context, req *astriaPb.ExecuteBlockRequest) (*astriaPb.Block, error) {
-	log.WithFields(
+func (s *ExecutionServiceServerV1Alpha2) ExecuteBlock(ctx context.Context, req *astriaPb.ExecuteBlockRequest) (_ *astriaPb.Block, err error) {
+	ctx, span := tracer.Start(ctx, "ExecutionService.ExecuteBlock")
+	defer func() { endSpan(span, err) }()
+	logger := requestLog(ctx)
+	start := time.Now()
+	s.lastExecuteBlock.Store(start.UnixNano())
+	logger.WithFields(
 		log.Fields{
-			"prevBlockHash": hex.EncodeToString(req.PrevBlockHash),
-			"txCount":       len(req.Transactions),
+			"prevHash": hex.EncodeToString(req.PrevBlockHash),
+			"txCount":  len(req.Transactions),
 		},
 	).Debugf("ExecuteBlock called")
 
-	// Filter out any Deposit txs since we don't currently support them
+	// Filter out any Deposit txs since we don't currently support them, as
+	// well as any txs which can't be decoded or break the transaction limits
 	txsToProcess := [][]byte{}
 	for idx, tx := range req.Transactions {
 		if tx.GetDeposit() != nil {
-			log.Info("Deposit transactions detected, not implemented for chain, skipping", "index", idx)
-		} else {
-			txsToProcess = append(txsToProcess, tx.GetSequencedData())
+			logger.WithField("index", idx).Info("Deposit transactions detected, not implemented for chain, skipping")
+			skippedTxs.WithLabelValues("deposit").Inc()
+			continue
 		}
+		if s.txLimits.MaxTxsPerBlock > 0 && len(txsToProcess) >= s.txLimits.MaxTxsPerBlock {
+			logger.WithFields(log.Fields{
+				"rule":  RuleMaxTxsPerBlock,
+				"limit": s.txLimits.MaxTxsPerBlock,
+			}).Warn("max txs per block reached, skipping remaining txs")
+			skippedTxs.WithLabelValues(string(RuleMaxTxsPerBlock)).Add(float64(len(req.Transactions) - idx))
+			break
+		}
+		if err := s.checkTx(tx.GetSequencedData()); err != nil {
+			logger.WithFields(log.Fields{
+				"index":     idx,
+				fieldTxHash: txHash(tx.GetSequencedData()),
+			}).Warnf("skipping invalid tx: %s", err)
+			continue
+		}
+		txsToProcess = append(txsToProcess, tx.GetSequencedData())
 	}
 
-	block := NewBlock(req.PrevBlockHash, uint32(len(s.rollupBlocks.Blocks)), txsToProcess, req.Timestamp.AsTime())
-	err := s.rollupBlocks.AddBlock(block)
+	block := NewBlock(req.PrevBlockHash, s.rollupBlocks.Height(), txsToProcess, req.Timestamp.AsTime())
+	// the tx hashes match those of the submission spans of this node
+	hashes := make([]string, len(txsToProcess))
+	for i, tx := range txsToProcess {
+		hashes[i] = txHash(tx)
+	}
+	span.SetAttributes(
+		attrHeight.Int64(int64(block.Height)),
+		attrBlockHash.String(hex.EncodeToString(block.Hash[:])),
+		attribute.Int("messenger.sequenced_tx_count", len(req.Transactions)),
+		attrTxHashes.StringSlice(hashes),
+	)
+	err = s.rollupBlocks.AddBlock(block)
 	if err != nil {
 		return nil, err
 	}
@@ -125,13 +184,55 @@ func (s *ExecutionServiceServerV1Alpha2) ExecuteBlock(ctx context.Context, req *
 		return nil, errors.New("failed to convert block to protobuf")
 	}
 
-	log.WithField("blockHash", hex.EncodeToString(block.Hash[:])).Debugf("ExecuteBlock completed")
+	blockHeight.WithLabelValues(string(CommitmentExecuted)).Set(float64(block.Height))
+	executedTxs.Add(float64(len(txsToProcess)))
+	executeBlockDuration.Observe(time.Since(start).Seconds())
+
+	logger.WithFields(log.Fields{
+		fieldHeight: block.Height,
+		fieldHash:   hex.EncodeToString(block.Hash[:]),
+		"txCount":   len(txsToProcess),
+	}).Debug("ExecuteBlock completed")
 	return blockPb, nil
 }
 
+// LastExecuteBlock returns when ExecuteBlock was last called, or the zero
+// time if it was never called.
+func (s *ExecutionServiceServerV1Alpha2) LastExecuteBlock() time.Time {
+	last := s.lastExecuteBlock.Load()
+	if last == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, last)
+}
+
+// checkTx decodes a sequenced transaction and checks it against the
+// transaction limits.
+func (s *ExecutionServiceServerV1Alpha2) checkTx(data []byte) error {
+	tx, err := decodeTx(data)
+	if err != nil {
+		txDecodeFailures.Inc()
+		skippedTxs.WithLabelValues("decode").Inc()
+		return err
+	}
+	if err := s.txLimits.CheckTx(tx); err != nil {
+		reason := string(RuleInvalidTx)
+		var ruleErr *TxRuleError
+		if errors.As(err, &ruleErr) {
+			reason = string(ruleErr.Rule)
+		}
+		skippedTxs.WithLabelValues(reason).Inc()
+		return err
+	}
+	return nil
+}
+
 // GetCommitmentState retrieves the current commitment state of the blockchain.
-func (s *ExecutionServiceServerV1Alpha2) GetCommitmentState(ctx context.Context, req *astriaPb.GetCommitmentStateRequest) (*astriaPb.CommitmentState, error) {
-	log.Debug("GetCommitmentState called")
+func (s *ExecutionServiceServerV1Alpha2) GetCommitmentState(ctx context.Context, req *astriaPb.GetCommitmentStateRequest) (_ *astriaPb.CommitmentState, err error) {
+	ctx, span := tracer.Start(ctx, "ExecutionService.GetCommitmentState")
+	defer func() { endSpan(span, err) }()
+	logger := requestLog(ctx)
+	logger.Debug("GetCommitmentState called")
 	soft, err := s.rollupBlocks.GetSoftBlock().ToPb()
 	if err != nil {
 		return nil, err
@@ -146,7 +247,7 @@ func (s *ExecutionServiceServerV1Alpha2) GetCommitmentState(ctx context.Context,
 		Firm: firm,
 	}
 
-	log.WithFields(
+	logger.WithFields(
 		log.Fields{
 			"soft": soft.Number,
 			"firm": firm.Number,
@@ -156,8 +257,15 @@ func (s *ExecutionServiceServerV1Alpha2) GetCommitmentState(ctx context.Context,
 }
 
 // UpdateCommitmentState updates the commitment state of the blockchain.
-func (s *ExecutionServiceServerV1Alpha2) UpdateCommitmentState(ctx context.Context, req *astriaPb.UpdateCommitmentStateRequest) (*astriaPb.CommitmentState, error) {
-	log.WithFields(
+func (s *ExecutionServiceServerV1Alpha2) UpdateCommitmentState(ctx context.Context, req *astriaPb.UpdateCommitmentStateRequest) (_ *astriaPb.CommitmentState, err error) {
+	ctx, span := tracer.Start(ctx, "ExecutionService.UpdateCommitmentState")
+	span.SetAttributes(
+		attribute.Int64("messenger.soft_height", int64(req.CommitmentState.Soft.Number)),
+		attribute.Int64("messenger.firm_height", int64(req.CommitmentState.Firm.Number)),
+	)
+	defer func() { endSpan(span, err) }()
+	logger := requestLog(ctx)
+	logger.WithFields(
 		log.Fields{
 			"soft":     req.CommitmentState.Soft.Number,
 			"softHash": hex.EncodeToString(req.CommitmentState.Soft.Hash),
@@ -187,13 +295,14 @@ func (s *ExecutionServiceServerV1Alpha2) UpdateCommitmentState(ctx context.Conte
 	}
 
 	// update the commitment state
-	s.rollupBlocks.soft = softHeight
-	s.rollupBlocks.firm = firmHeight
+	s.rollupBlocks.SetCommitment(softHeight, firmHeight)
+	blockHeight.WithLabelValues(string(CommitmentSoft)).Set(float64(softHeight))
+	blockHeight.WithLabelValues(string(CommitmentFirm)).Set(float64(firmHeight))
 
-	log.WithFields(
+	logger.WithFields(
 		log.Fields{
-			"soft": s.rollupBlocks.soft,
-			"firm": s.rollupBlocks.firm,
+			"soft": softHeight,
+			"firm": firmHeight,
 		},
 	).Debugf("UpdateCommitmentState completed")
 	return req.CommitmentState, nil
@@ -0,0 +1,157 @@
+package messenger
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Genesis is the genesis of a rollup, which all of its nodes must share. It
+// is read from the JSON file of GENESIS_FILE, and written by the genesis
+// command of the node binary:
+//
+//	{
+//	  "rollupName": "messenger",
+//	  "genesisTime": "2024-01-01T00:00:00Z",
+//	  "messages": [{"sender": "astria", "message": "hello, world!"}],
+//	  "sequencerGenesisBlockHeight": 1,
+//	  "celestiaBaseBlockHeight": 1,
+//	  "celestiaBlockVariance": 1
+//	}
+type Genesis struct {
+	RollupName string `json:"rollupName"`
+	// GenesisTime is the timestamp of the genesis block
+	GenesisTime time.Time `json:"genesisTime"`
+	// Messages are the transactions of the genesis block, the initial state
+	// of the rollup. Replies and reactions may refer to earlier messages
+	Messages []Transaction `json:"messages"`
+	// the first sequencer and Celestia heights the conductor reads, and the
+	// number of Celestia blocks a sequencer block may be found in
+	SequencerGenesisBlockHeight uint32 `json:"sequencerGenesisBlockHeight"`
+	CelestiaBaseBlockHeight     uint32 `json:"celestiaBaseBlockHeight"`
+	CelestiaBlockVariance       uint32 `json:"celestiaBlockVariance"`
+}
+
+// DefaultGenesis returns the genesis used without a genesis file, a single
+// "hello, world!" message at the unix epoch.
+func DefaultGenesis(rollupName string) *Genesis {
+	return &Genesis{
+		RollupName:  rollupName,
+		GenesisTime: time.Unix(0, 0).UTC(),
+		Messages: []Transaction{
+			{Sender: "astria", Message: "hello, world!"},
+		},
+		SequencerGenesisBlockHeight: 1,
+		CelestiaBaseBlockHeight:     1,
+		CelestiaBlockVariance:       1,
+	}
+}
+
+// LoadGenesisFile reads and validates a genesis file.
+func LoadGenesisFile(path string) (*Genesis, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var g Genesis
+	if err := json.Unmarshal(data, &g); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := g.Validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &g, nil
+}
+
+// Validate checks the genesis, returning the first invalid value.
+func (g *Genesis) Validate() error {
+	if g.RollupName == "" {
+		return errors.New("rollupName must not be empty")
+	}
+	if g.GenesisTime.IsZero() {
+		return errors.New("genesisTime must be set")
+	}
+	if g.SequencerGenesisBlockHeight == 0 {
+		return errors.New("sequencerGenesisBlockHeight must be positive")
+	}
+	if g.CelestiaBaseBlockHeight == 0 {
+		return errors.New("celestiaBaseBlockHeight must be positive")
+	}
+	if g.CelestiaBlockVariance == 0 {
+		return errors.New("celestiaBlockVariance must be positive")
+	}
+	if _, err := g.encodeTxs(); err != nil {
+		return err
+	}
+	return nil
+}
+
+// CheckTxs checks the messages with the rules of submitted transactions, in
+// order, so that replies and reactions may only refer to earlier messages.
+// It returns the first invalid message. Genesis messages are written by the
+// operators rather than submitted, so they need no proof of work.
+func (g *Genesis) CheckTxs(limits TxLimits) error {
+	limits.PowDifficulty = 0
+	state := NewMessengerState()
+	block := &Block{Height: 0, Timestamp: g.GenesisTime.UTC()}
+	for i := range g.Messages {
+		tx := g.Messages[i]
+		if err := limits.CheckTx(&tx); err != nil {
+			return fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		if err := state.ApplyTx(block, i, &tx); err != nil {
+			return fmt.Errorf("messages[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// encodeTxs returns the encoded messages.
+func (g *Genesis) encodeTxs() ([][]byte, error) {
+	txs := make([][]byte, 0, len(g.Messages))
+	for i, tx := range g.Messages {
+		if tx.Sender == "" {
+			return nil, fmt.Errorf("messages[%d]: sender must not be empty", i)
+		}
+		encoded, err := encodeTx(tx)
+		if err != nil {
+			return nil, fmt.Errorf("messages[%d]: %w", i, err)
+		}
+		txs = append(txs, encoded)
+	}
+	return txs, nil
+}
+
+// Hash returns the genesis hash, the sha256 of the genesis encoded as JSON
+// with the time in UTC. It doesn't depend on the formatting of the file, so
+// nodes loading the same genesis agree on it.
+func (g *Genesis) Hash() ([32]byte, error) {
+	canonical := *g
+	canonical.GenesisTime = g.GenesisTime.UTC()
+	data, err := json.Marshal(canonical)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	return sha256.Sum256(data), nil
+}
+
+// Block returns the genesis block, whose hash is the genesis hash.
+func (g *Genesis) Block() (Block, error) {
+	txs, err := g.encodeTxs()
+	if err != nil {
+		return Block{}, err
+	}
+	hash, err := g.Hash()
+	if err != nil {
+		return Block{}, err
+	}
+	return Block{
+		Hash:      hash,
+		Height:    0,
+		Timestamp: g.GenesisTime.UTC(),
+		Txs:       txs,
+	}, nil
+}
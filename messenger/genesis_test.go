@@ -0,0 +1,51 @@
+package messenger
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestGenesisCheckTxs(t *testing.T) {
+	limits := TxLimits{MaxMessageBytes: 16, MaxSenderLength: 8, MaxChannelLength: 8, PowDifficulty: 8}
+	valid := []Transaction{
+		{Sender: "astria", Message: "hello, world!"},
+		{Type: TxTypeReply, Sender: "bob", Message: "hi", ParentID: "0-0"},
+		{Type: TxTypeReaction, Sender: "carol", Reaction: "+1", ParentID: "0-1"},
+	}
+	g := DefaultGenesis("test")
+	g.Messages = valid
+	// genesis messages need no proof of work
+	if err := g.CheckTxs(limits); err != nil {
+		t.Fatalf("valid genesis: %s", err)
+	}
+
+	for name, test := range map[string]struct {
+		tx   Transaction
+		rule string
+		err  string
+	}{
+		"too long":        {tx: Transaction{Sender: "alice", Message: strings.Repeat("x", 17)}, rule: RuleMaxMessageBytes},
+		"control chars":   {tx: Transaction{Sender: "al\x00ice", Message: "hi"}, rule: RuleControlChars},
+		"missing parent":  {tx: Transaction{Type: TxTypeReply, Sender: "alice", Message: "hi", ParentID: "0-9"}, err: "not found"},
+		"later parent":    {tx: Transaction{Type: TxTypeReply, Sender: "alice", Message: "hi", ParentID: "0-4"}, err: "not found"},
+		"reaction parent": {tx: Transaction{Type: TxTypeReply, Sender: "alice", Message: "hi", ParentID: "0-2"}, err: "parent must be"},
+	} {
+		g.Messages = append(append([]Transaction(nil), valid...), test.tx, Transaction{Sender: "dave", Message: "later"})
+		err := g.CheckTxs(limits)
+		if err == nil {
+			t.Errorf("%s: CheckTxs succeeded", name)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "messages[3]: ") {
+			t.Errorf("%s: error %q doesn't name the invalid message", name, err)
+		}
+		var ruleErr *TxRuleError
+		if test.rule != "" && (!errors.As(err, &ruleErr) || ruleErr.Rule != test.rule) {
+			t.Errorf("%s: error %q, want rule %s", name, err, test.rule)
+		}
+		if test.err != "" && !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %q, want %q", name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package messenger
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc/connectivity"
+)
+
+// ReadinessCheck is the outcome of one readiness check.
+type ReadinessCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// ReadinessResponse is the response of /readyz.
+type ReadinessResponse struct {
+	// Status is ready or not ready
+	Status string           `json:"status"`
+	Checks []ReadinessCheck `json:"checks"`
+	// Heights are the executed, soft and firm heights
+	Heights map[CommitmentLevel]uint32 `json:"heights"`
+}
+
+// ReadinessLimits are the thresholds of the readiness checks. A zero value
+// disables the check.
+type ReadinessLimits struct {
+	// MaxExecuteAge is the longest the conductor may go without calling
+	// ExecuteBlock, counted from startup until the first call
+	MaxExecuteAge time.Duration
+	// MaxSoftLag is the most executed blocks which may not be soft committed
+	MaxSoftLag uint32
+	// MaxFirmLag is the most soft blocks which may not be firm committed
+	MaxFirmLag uint32
+}
+
+// healthz reports that the process is alive.
+func (a *App) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"status":"ok"}`))
+}
+
+// readyz reports whether the node can serve its clients: the execution API
+// is serving, the composer connection is usable, the conductor is driving
+// execution and commitments are keeping up. It responds 503 if any check
+// fails.
+func (a *App) readyz(w http.ResponseWriter, r *http.Request) {
+	res := a.readiness(time.Now())
+
+	status := http.StatusOK
+	if res.Status != "ready" {
+		status = http.StatusServiceUnavailable
+	}
+	resJson, err := json.Marshal(res)
+	if err != nil {
+		requestLog(r.Context()).Errorf("error marshalling readiness: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resJson)
+}
+
+// readiness runs the readiness checks.
+func (a *App) readiness(now time.Time) *ReadinessResponse {
+	executed := a.rollupBlocks.CommittedHeight(CommitmentExecuted)
+	soft := a.rollupBlocks.CommittedHeight(CommitmentSoft)
+	firm := a.rollupBlocks.CommittedHeight(CommitmentFirm)
+	res := &ReadinessResponse{
+		Status: "ready",
+		Heights: map[CommitmentLevel]uint32{
+			CommitmentExecuted: executed,
+			CommitmentSoft:     soft,
+			CommitmentFirm:     firm,
+		},
+	}
+	add := func(name string, ok bool, format string, args ...interface{}) {
+		res.Checks = append(res.Checks, ReadinessCheck{Name: name, OK: ok, Message: fmt.Sprintf(format, args...)})
+		if !ok {
+			res.Status = "not ready"
+		}
+	}
+
+	if a.executionServing.Load() {
+		add("execution_api", true, "serving on %s", a.executionRPC)
+	} else {
+		add("execution_api", false, "not serving")
+	}
+
+	// the connection is established lazily, idle is fine until the first
+	// submission
+	state := a.sequencerClient.ComposerState()
+	switch state {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		add("composer", false, "connection %s", state)
+	default:
+		add("composer", true, "connection %s", state)
+	}
+
+	if limit := a.readinessLimits.MaxExecuteAge; limit > 0 {
+		last := a.executionServer.LastExecuteBlock()
+		switch {
+		case !last.IsZero() && now.Sub(last) <= limit:
+			add("execute_block", true, "last called %s ago", now.Sub(last).Round(time.Millisecond))
+		case !last.IsZero():
+			add("execute_block", false, "last called %s ago, more than %s", now.Sub(last).Round(time.Millisecond), limit)
+		case now.Sub(a.startedAt) <= limit:
+			add("execute_block", true, "not called yet, started %s ago", now.Sub(a.startedAt).Round(time.Millisecond))
+		default:
+			add("execute_block", false, "not called since startup %s ago", now.Sub(a.startedAt).Round(time.Millisecond))
+		}
+	}
+
+	if limit := a.readinessLimits.MaxSoftLag; limit > 0 {
+		lag := executed - min(executed, soft)
+		add("soft_lag", lag <= limit, "%d executed blocks not soft committed, limit %d", lag, limit)
+	}
+	if limit := a.readinessLimits.MaxFirmLag; limit > 0 {
+		lag := soft - min(soft, firm)
+		add("firm_lag", lag <= limit, "%d soft blocks not firm committed, limit %d", lag, limit)
+	}
+	return res
+}
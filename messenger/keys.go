@@ -0,0 +1,393 @@
+package messenger
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// The sequencer key signs the transactions the node sends to the sequencer
+// directly. It is provided by exactly one of SEQUENCER_PRIVATE, a hex seed,
+// SEQUENCER_KEY_FILE, a file holding the hex seed which only its owner may
+// access, SEQUENCER_KEYSTORE_FILE, a passphrase protected keystore, or
+// SEQUENCER_SIGNER_SOCKET, an external signer on a unix socket which keeps
+// the key out of the node.
+
+// KeyProvider signs with the sequencer key.
+type KeyProvider interface {
+	// PublicKey returns the ed25519 public key.
+	PublicKey() ed25519.PublicKey
+	// Sign returns the ed25519 signature of message.
+	Sign(ctx context.Context, message []byte) ([]byte, error)
+}
+
+// SequencerAddress returns the sequencer address of a public key.
+func SequencerAddress(publicKey ed25519.PublicKey) [20]byte {
+	hash := sha256.Sum256(publicKey)
+	var address [20]byte
+	copy(address[:], hash[:20])
+	return address
+}
+
+// parseSeed decodes a hex encoded ed25519 seed, ignoring surrounding
+// whitespace.
+func parseSeed(value string) ([]byte, error) {
+	seed, err := hex.DecodeString(strings.TrimSpace(value))
+	if err != nil {
+		return nil, errors.New("must be hex encoded")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	return seed, nil
+}
+
+// localKey is a key held in memory.
+type localKey struct {
+	private ed25519.PrivateKey
+}
+
+// NewLocalKeyProvider returns a provider of the key of an ed25519 seed.
+func NewLocalKeyProvider(seed []byte) (KeyProvider, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("seed must be %d bytes, got %d", ed25519.SeedSize, len(seed))
+	}
+	return &localKey{private: ed25519.NewKeyFromSeed(seed)}, nil
+}
+
+func (k *localKey) PublicKey() ed25519.PublicKey {
+	return k.private.Public().(ed25519.PublicKey)
+}
+
+func (k *localKey) Sign(_ context.Context, message []byte) ([]byte, error) {
+	return ed25519.Sign(k.private, message), nil
+}
+
+// ReadSeedFile returns the seed of a file holding a hex seed. The file must
+// not be accessible by group or other users.
+func ReadSeedFile(path string) ([]byte, error) {
+	if err := checkPrivateFile(path); err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := parseSeed(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return seed, nil
+}
+
+// LoadKeyFile returns the key of a file holding a hex seed, see
+// ReadSeedFile.
+func LoadKeyFile(path string) (KeyProvider, error) {
+	seed, err := ReadSeedFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewLocalKeyProvider(seed)
+}
+
+// checkPrivateFile returns an error if a file is accessible by group or
+// other users.
+func checkPrivateFile(path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if perm := info.Mode().Perm(); perm&0o077 != 0 {
+		return fmt.Errorf("%s is accessible by other users (mode %04o), restrict it to its owner with chmod 600", path, perm)
+	}
+	return nil
+}
+
+// Keystore is a sequencer key encrypted with a passphrase. The encryption
+// key is derived from the passphrase with scrypt and the seed sealed with
+// AES-256-GCM, authenticating the public key along with it.
+type Keystore struct {
+	Version   int            `json:"version"`
+	Address   string         `json:"address"`
+	PublicKey string         `json:"publicKey"`
+	Crypto    KeystoreCrypto `json:"crypto"`
+}
+
+// KeystoreCrypto holds the parameters and ciphertext of a keystore, with
+// binary values hex encoded.
+type KeystoreCrypto struct {
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       string `json:"salt"`
+	Cipher     string `json:"cipher"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+const (
+	keystoreVersion = 1
+	keystoreKDF     = "scrypt"
+	keystoreCipher  = "aes-256-gcm"
+	// scrypt parameters of new keystores, using 128 MiB
+	keystoreN = 1 << 17
+	keystoreR = 8
+	keystoreP = 1
+	// bounds of the scrypt parameters of keystores opened, limiting the
+	// memory and time used
+	keystoreMaxN = 1 << 20
+	keystoreMaxR = 32
+	keystoreMaxP = 16
+)
+
+// errKeystorePassphrase is returned for a wrong passphrase, which can't be
+// told apart from a corrupted keystore.
+var errKeystorePassphrase = errors.New("wrong passphrase or corrupted keystore")
+
+// keystoreAEAD returns the cipher of a passphrase.
+func keystoreAEAD(passphrase string, salt []byte, n int, r int, p int) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, n, r, p, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// EncryptKeystore returns the JSON keystore of a seed.
+func EncryptKeystore(seed []byte, passphrase string) ([]byte, error) {
+	if passphrase == "" {
+		return nil, errors.New("empty passphrase")
+	}
+	key, err := NewLocalKeyProvider(seed)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	aead, err := keystoreAEAD(passphrase, salt, keystoreN, keystoreR, keystoreP)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	publicKey := key.PublicKey()
+	address := SequencerAddress(publicKey)
+	ks := Keystore{
+		Version:   keystoreVersion,
+		Address:   hex.EncodeToString(address[:]),
+		PublicKey: hex.EncodeToString(publicKey),
+		Crypto: KeystoreCrypto{
+			KDF:        keystoreKDF,
+			N:          keystoreN,
+			R:          keystoreR,
+			P:          keystoreP,
+			Salt:       hex.EncodeToString(salt),
+			Cipher:     keystoreCipher,
+			Nonce:      hex.EncodeToString(nonce),
+			Ciphertext: hex.EncodeToString(aead.Seal(nil, nonce, seed, publicKey)),
+		},
+	}
+	return json.MarshalIndent(ks, "", "  ")
+}
+
+// DecryptKeystore returns the seed of a JSON keystore.
+func DecryptKeystore(data []byte, passphrase string) ([]byte, error) {
+	var ks Keystore
+	if err := json.Unmarshal(data, &ks); err != nil {
+		return nil, fmt.Errorf("invalid keystore: %w", err)
+	}
+	c := ks.Crypto
+	if ks.Version != keystoreVersion || c.KDF != keystoreKDF || c.Cipher != keystoreCipher {
+		return nil, fmt.Errorf("unsupported keystore version %d with %s and %s", ks.Version, c.KDF, c.Cipher)
+	}
+	if c.N <= 1 || c.N > keystoreMaxN || c.R <= 0 || c.R > keystoreMaxR || c.P <= 0 || c.P > keystoreMaxP {
+		return nil, errors.New("invalid keystore scrypt parameters")
+	}
+	publicKey, err1 := hex.DecodeString(ks.PublicKey)
+	salt, err2 := hex.DecodeString(c.Salt)
+	nonce, err3 := hex.DecodeString(c.Nonce)
+	ciphertext, err4 := hex.DecodeString(c.Ciphertext)
+	if err := errors.Join(err1, err2, err3, err4); err != nil {
+		return nil, fmt.Errorf("invalid keystore: %w", err)
+	}
+
+	aead, err := keystoreAEAD(passphrase, salt, c.N, c.R, c.P)
+	if err != nil {
+		return nil, err
+	}
+	if len(nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid keystore nonce")
+	}
+	seed, err := aead.Open(nil, nonce, ciphertext, publicKey)
+	if err != nil {
+		return nil, errKeystorePassphrase
+	}
+	return seed, nil
+}
+
+// LoadKeystore returns the key of a keystore file.
+func LoadKeystore(path string, passphrase string) (KeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := DecryptKeystore(data, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return NewLocalKeyProvider(seed)
+}
+
+// SignerRequest is a request to an external signer. A connection to the
+// signer's unix socket carries one JSON request and one JSON response, each
+// followed by a newline. Binary values are base64 encoded.
+type SignerRequest struct {
+	// Method is publicKey or sign
+	Method string `json:"method"`
+	// Message is the message to sign
+	Message []byte `json:"message,omitempty"`
+}
+
+// SignerResponse is the response of an external signer, with an error
+// message if the request failed.
+type SignerResponse struct {
+	PublicKey []byte `json:"publicKey,omitempty"`
+	Signature []byte `json:"signature,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// Methods of the external signer.
+const (
+	SignerMethodPublicKey = "publicKey"
+	SignerMethodSign      = "sign"
+)
+
+// socketSigner is a key held by an external signer.
+type socketSigner struct {
+	path      string
+	timeout   time.Duration
+	publicKey ed25519.PublicKey
+}
+
+// NewSocketSigner returns a provider of the key of the external signer
+// listening on a unix socket, whose requests time out after timeout.
+func NewSocketSigner(ctx context.Context, path string, timeout time.Duration) (KeyProvider, error) {
+	s := &socketSigner{path: path, timeout: timeout}
+	res, err := s.call(ctx, SignerRequest{Method: SignerMethodPublicKey})
+	if err != nil {
+		return nil, err
+	}
+	if len(res.PublicKey) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("external signer returned a %d byte public key", len(res.PublicKey))
+	}
+	s.publicKey = res.PublicKey
+	return s, nil
+}
+
+// call sends a request to the signer.
+func (s *socketSigner) call(ctx context.Context, req SignerRequest) (*SignerResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "unix", s.path)
+	if err != nil {
+		return nil, fmt.Errorf("external signer: %w", err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("external signer: %w", err)
+	}
+	var res SignerResponse
+	if err := json.NewDecoder(conn).Decode(&res); err != nil {
+		return nil, fmt.Errorf("external signer: %w", err)
+	}
+	if res.Error != "" {
+		return nil, fmt.Errorf("external signer: %s", res.Error)
+	}
+	return &res, nil
+}
+
+func (s *socketSigner) PublicKey() ed25519.PublicKey {
+	return s.publicKey
+}
+
+// Sign asks the signer for a signature, which is checked so that a
+// misbehaving signer can't make the node submit invalid transactions.
+func (s *socketSigner) Sign(ctx context.Context, message []byte) ([]byte, error) {
+	res, err := s.call(ctx, SignerRequest{Method: SignerMethodSign, Message: message})
+	if err != nil {
+		return nil, err
+	}
+	if !ed25519.Verify(s.publicKey, message, res.Signature) {
+		return nil, errors.New("external signer returned an invalid signature")
+	}
+	return res.Signature, nil
+}
+
+// KeyConfig configures the sequencer key provider. Exactly one of Seed,
+// KeyFile, KeystoreFile and SignerSocket must be set.
+type KeyConfig struct {
+	Seed string
+	// KeyFile holds a hex seed
+	KeyFile string
+	// KeystoreFile is opened with KeystorePassphrase, or the contents of
+	// KeystorePassphraseFile
+	KeystoreFile           string
+	KeystorePassphrase     string
+	KeystorePassphraseFile string
+	SignerSocket           string
+	SignerTimeout          time.Duration
+}
+
+// newKeyProvider returns the sequencer key provider of a key config.
+func newKeyProvider(ctx context.Context, cfg KeyConfig) (KeyProvider, error) {
+	switch {
+	case cfg.Seed != "":
+		seed, err := parseSeed(cfg.Seed)
+		if err != nil {
+			return nil, fmt.Errorf("SEQUENCER_PRIVATE: %w", err)
+		}
+		return NewLocalKeyProvider(seed)
+	case cfg.KeyFile != "":
+		return LoadKeyFile(cfg.KeyFile)
+	case cfg.KeystoreFile != "":
+		passphrase := cfg.KeystorePassphrase
+		if cfg.KeystorePassphraseFile != "" {
+			data, err := os.ReadFile(cfg.KeystorePassphraseFile)
+			if err != nil {
+				return nil, err
+			}
+			passphrase = strings.TrimRight(string(data), "\r\n")
+		}
+		return LoadKeystore(cfg.KeystoreFile, passphrase)
+	case cfg.SignerSocket != "":
+		return NewSocketSigner(ctx, cfg.SignerSocket, cfg.SignerTimeout)
+	default:
+		return nil, errors.New("no sequencer key configured")
+	}
+}
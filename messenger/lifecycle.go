@@ -0,0 +1,106 @@
+package messenger
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// component is a long running part of the app managed by a Lifecycle.
+type component struct {
+	name string
+	// serve runs the component until it fails or is stopped. Its context is
+	// cancelled when the shutdown starts.
+	serve func(ctx context.Context) error
+	// stop stops the component gracefully, giving up once ctx is done, which
+	// makes serve return
+	stop func(ctx context.Context) error
+}
+
+// Lifecycle starts the components of the app and stops them together, in
+// reverse order, once the context is cancelled or any of them stops on its
+// own.
+type Lifecycle struct {
+	components      []component
+	shutdownTimeout time.Duration
+}
+
+func NewLifecycle(shutdownTimeout time.Duration) *Lifecycle {
+	return &Lifecycle{shutdownTimeout: shutdownTimeout}
+}
+
+// Add adds a component, which is started after and stopped before the
+// components added before it.
+func (l *Lifecycle) Add(name string, serve func(ctx context.Context) error, stop func(ctx context.Context) error) {
+	l.components = append(l.components, component{name: name, serve: serve, stop: stop})
+}
+
+type componentResult struct {
+	name string
+	err  error
+}
+
+// Run starts all components and blocks until they are stopped. It returns
+// the error of the component which caused the shutdown, if any, along with
+// the errors of stopping the others.
+func (l *Lifecycle) Run(ctx context.Context) error {
+	serveCtx, cancelServe := context.WithCancel(ctx)
+	defer cancelServe()
+	results := make(chan componentResult, len(l.components))
+	for _, c := range l.components {
+		c := c
+		go func() {
+			results <- componentResult{c.name, c.serve(serveCtx)}
+		}()
+	}
+
+	var errs []error
+	running := len(l.components)
+	select {
+	case <-ctx.Done():
+		log.Info("Shutting down...")
+	case res := <-results:
+		running--
+		if res.err != nil {
+			log.Errorf("%s failed, shutting down: %s\n", res.name, res.err)
+			errs = append(errs, fmt.Errorf("%s: %w", res.name, res.err))
+		} else {
+			log.Warnf("%s stopped, shutting down\n", res.name)
+		}
+	}
+
+	cancelServe()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), l.shutdownTimeout)
+	defer cancel()
+	for i := len(l.components) - 1; i >= 0; i-- {
+		c := l.components[i]
+		if err := c.stop(shutdownCtx); err != nil {
+			log.Errorf("error stopping %s: %s\n", c.name, err)
+			errs = append(errs, fmt.Errorf("stopping %s: %w", c.name, err))
+		}
+	}
+
+	for ; running > 0; running-- {
+		// take the components which already returned before checking the
+		// timeout, which a slow stop may have used up
+		var res componentResult
+		select {
+		case res = <-results:
+		default:
+			select {
+			case res = <-results:
+			case <-shutdownCtx.Done():
+				errs = append(errs, fmt.Errorf("%d components still running after %s", running, l.shutdownTimeout))
+				return errors.Join(errs...)
+			}
+		}
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", res.name, res.err))
+		}
+	}
+	log.Info("Shut down gracefully")
+	return errors.Join(errs...)
+}
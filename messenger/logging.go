@@ -0,0 +1,165 @@
+package messenger
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Log field names shared across the package, so that the logs of a block, a
+// transaction or a client can be found with one query.
+const (
+	fieldRequestID = "requestID"
+	fieldTraceID   = "traceID"
+	fieldHeight    = "height"
+	fieldHash      = "hash"
+	fieldTxHash    = "txHash"
+	fieldClientID  = "clientID"
+)
+
+// requestIDHeader is the REST header and gRPC metadata key carrying the
+// request ID. A valid ID sent by the caller is kept, otherwise one is
+// generated, and it is echoed in the response.
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds request IDs sent by callers.
+const maxRequestIDLength = 64
+
+type loggerKey struct{}
+
+// withLogger returns a context carrying a request logger.
+func withLogger(ctx context.Context, entry *log.Entry) context.Context {
+	return context.WithValue(ctx, loggerKey{}, entry)
+}
+
+// requestLog returns the logger of the request ctx belongs to, with its
+// request ID and trace ID, or the standard logger outside of requests.
+func requestLog(ctx context.Context) *log.Entry {
+	entry, ok := ctx.Value(loggerKey{}).(*log.Entry)
+	if !ok {
+		entry = log.NewEntry(log.StandardLogger())
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		entry = entry.WithField(fieldTraceID, span.TraceID().String())
+	}
+	return entry
+}
+
+// requestID returns the ID sent by a caller if it is valid, or a new one.
+func requestID(sent string) string {
+	if validRequestID(sent) {
+		return sent
+	}
+	id, err := randomID(8)
+	if err != nil {
+		// the ID only correlates logs, so the request goes on without one
+		log.Errorf("error generating request id: %s\n", err)
+	}
+	return id
+}
+
+// validRequestID returns whether an ID sent by a caller is short printable
+// ASCII.
+func validRequestID(sent string) bool {
+	if sent == "" || len(sent) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range sent {
+		if c < '!' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+// requestIDMiddleware gives every REST request an ID, returned in the
+// X-Request-ID header and added to the logs of the request.
+func requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := requestID(r.Header.Get(requestIDHeader))
+		w.Header().Set(requestIDHeader, id)
+		entry := log.WithField(fieldRequestID, id)
+
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r.WithContext(withLogger(r.Context(), entry)))
+		entry.WithFields(log.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   rec.status,
+			"duration": time.Since(start).String(),
+		}).Debug("request handled")
+	})
+}
+
+// grpcRequestID returns the request ID of a gRPC call and sends it back in
+// the response header.
+func grpcRequestID(ctx context.Context) string {
+	sent := ""
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get(requestIDHeader); len(values) > 0 {
+			sent = values[0]
+		}
+	}
+	id := requestID(sent)
+	// fails only if the header was already sent, which it can't have been
+	grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+	return id
+}
+
+// logGRPCCall logs a finished gRPC call, at warning level if it failed on
+// the server side. Errors of the caller, such as an unknown ID, are only
+// logged at debug level.
+func logGRPCCall(entry *log.Entry, method string, start time.Time, err error) {
+	code := status.Code(err)
+	entry = entry.WithFields(log.Fields{
+		"method":   method,
+		"code":     code.String(),
+		"duration": time.Since(start).String(),
+	})
+	switch code {
+	case codes.OK:
+		entry.Debug("gRPC call handled")
+	case codes.Unknown, codes.Internal, codes.Unavailable, codes.DataLoss, codes.DeadlineExceeded:
+		entry.Warnf("gRPC call failed: %s", err)
+	default:
+		entry.Debugf("gRPC call failed: %s", err)
+	}
+}
+
+// unaryRequestIDInterceptor is the gRPC counterpart of requestIDMiddleware
+// for unary calls.
+func unaryRequestIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	entry := log.WithField(fieldRequestID, grpcRequestID(ctx))
+	start := time.Now()
+	res, err := handler(withLogger(ctx, entry), req)
+	logGRPCCall(entry, info.FullMethod, start, err)
+	return res, err
+}
+
+// streamRequestIDInterceptor is the gRPC counterpart of requestIDMiddleware
+// for streaming calls.
+func streamRequestIDInterceptor(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	entry := log.WithField(fieldRequestID, grpcRequestID(stream.Context()))
+	start := time.Now()
+	err := handler(srv, &loggerStream{ServerStream: stream, ctx: withLogger(stream.Context(), entry)})
+	logGRPCCall(entry, info.FullMethod, start, err)
+	return err
+}
+
+// loggerStream is a server stream whose context carries a request logger.
+type loggerStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *loggerStream) Context() context.Context {
+	return s.ctx
+}
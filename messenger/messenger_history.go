@@ -0,0 +1,203 @@
+package messenger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// parseMessageID parses a message ID back into its block height and tx index.
+func parseMessageID(id string) (uint32, int, error) {
+	heightStr, indexStr, ok := strings.Cut(id, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid message id %q", id)
+	}
+	height, err := strconv.ParseUint(heightStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid message id %q: %w", id, err)
+	}
+	index, err := strconv.Atoi(indexStr)
+	if err != nil || index < 0 {
+		return 0, 0, fmt.Errorf("invalid message id %q", id)
+	}
+	return uint32(height), index, nil
+}
+
+// before reports whether tx comes before the given chain position.
+func (tx *StoredTx) before(height uint32, index int) bool {
+	return tx.Height < height || (tx.Height == height && tx.Index < index)
+}
+
+// indexHistory adds a message or reply to the history and search indexes. Transactions
+// are applied in chain order, so every index stays sorted by position and,
+// as block timestamps are monotonic, by time.
+func (s *MessengerState) indexHistory(tx *StoredTx) {
+	s.history = append(s.history, tx)
+	s.bySender[tx.Sender] = append(s.bySender[tx.Sender], tx)
+	channel := tx.ChannelName()
+	s.byChannel[channel] = append(s.byChannel[channel], tx)
+	s.search.add(tx)
+}
+
+// MessageQuery selects a page of message history.
+type MessageQuery struct {
+	// Cursor is the ID of the last message of the previous page, exclusive.
+	Cursor string
+	// Since and Until bound the block timestamps, inclusive. Zero values are
+	// unbounded.
+	Since time.Time
+	Until time.Time
+	// Sender and Channel filter the messages if set.
+	Sender  string
+	Channel string
+	// Descending returns the newest messages first.
+	Descending bool
+	// Limit is the maximum number of messages to return, 0 for no limit.
+	Limit int
+}
+
+// MessagePage is a page of message history. NextCursor is empty on the
+// last page.
+type MessagePage struct {
+	Messages   []StoredTx `json:"messages"`
+	NextCursor string     `json:"nextCursor,omitempty"`
+}
+
+// QueryMessages returns a page of messages and replies matching the query.
+func (s *MessengerState) QueryMessages(q MessageQuery) (*MessagePage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	// start from the smallest index covering the filters
+	list := s.history
+	if q.Sender != "" {
+		list = s.bySender[q.Sender]
+	}
+	if q.Channel != "" {
+		if byChannel := s.byChannel[q.Channel]; q.Sender == "" || len(byChannel) < len(list) {
+			list = byChannel
+		}
+	}
+
+	// narrow down the range of the index to scan
+	lo, hi := 0, len(list)
+	if !q.Since.IsZero() {
+		lo = sort.Search(len(list), func(i int) bool { return !list[i].Timestamp.Before(q.Since) })
+	}
+	if !q.Until.IsZero() {
+		hi = sort.Search(len(list), func(i int) bool { return list[i].Timestamp.After(q.Until) })
+	}
+	if q.Cursor != "" {
+		height, index, err := parseMessageID(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		if q.Descending {
+			hi = min(hi, sort.Search(len(list), func(i int) bool { return !list[i].before(height, index) }))
+		} else {
+			lo = max(lo, sort.Search(len(list), func(i int) bool { return !list[i].before(height, index+1) }))
+		}
+	}
+
+	page := &MessagePage{Messages: []StoredTx{}}
+	matches := func(tx *StoredTx) bool {
+		return (q.Sender == "" || tx.Sender == q.Sender) &&
+			(q.Channel == "" || tx.ChannelName() == q.Channel)
+	}
+	for n := 0; n < hi-lo; n++ {
+		i := lo + n
+		if q.Descending {
+			i = hi - 1 - n
+		}
+		if !matches(list[i]) {
+			continue
+		}
+		if q.Limit > 0 && len(page.Messages) == q.Limit {
+			page.NextCursor = page.Messages[len(page.Messages)-1].ID
+			break
+		}
+		page.Messages = append(page.Messages, *list[i])
+	}
+	return page, nil
+}
+
+// parseQueryTime parses an RFC 3339 timestamp or unix seconds.
+func parseQueryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if secs, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+// parseMessageQuery reads a MessageQuery from the request parameters.
+func (a *App) parseMessageQuery(r *http.Request) (MessageQuery, error) {
+	params := r.URL.Query()
+	q := MessageQuery{
+		Cursor:     params.Get("cursor"),
+		Sender:     params.Get("sender"),
+		Channel:    params.Get("channel"),
+		Descending: true,
+		Limit:      a.messagesDefaultLimit,
+	}
+
+	switch params.Get("order") {
+	case "", "desc":
+	case "asc":
+		q.Descending = false
+	default:
+		return q, errors.New("order must be asc or desc")
+	}
+
+	var err error
+	if q.Since, err = parseQueryTime(params.Get("since")); err != nil {
+		return q, fmt.Errorf("invalid since: %w", err)
+	}
+	if q.Until, err = parseQueryTime(params.Get("until")); err != nil {
+		return q, fmt.Errorf("invalid until: %w", err)
+	}
+
+	if limit := params.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil || q.Limit <= 0 {
+			return q, errors.New("limit must be a positive integer")
+		}
+	}
+	if q.Limit > a.messagesMaxLimit {
+		q.Limit = a.messagesMaxLimit
+	}
+	return q, nil
+}
+
+// get a page of message history
+func (a *App) getMessages(w http.ResponseWriter, r *http.Request) {
+	logger := requestLog(r.Context())
+	q, err := a.parseMessageQuery(r)
+	if err != nil {
+		logger.Errorf("invalid messages query: %s\n", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := a.rollupBlocks.State().QueryMessages(q)
+	if err != nil {
+		logger.Errorf("error querying messages: %s\n", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pageJson, err := json.Marshal(page)
+	if err != nil {
+		logger.Errorf("error marshalling messages: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(pageJson)
+}
@@ -2,115 +2,171 @@ package messenger
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
 
+	"github.com/gorilla/mux"
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TxType identifies the kind of a rollup transaction.
+type TxType string
+
+const (
+	TxTypeMessage  TxType = "message"
+	TxTypeReply    TxType = "reply"
+	TxTypeReaction TxType = "reaction"
 )
 
 // Transaction represents a transaction in the blockchain.
+//
+// This is the JSON format used by the REST and websocket APIs; on chain
+// transactions are encoded with encodeTx. Plain messages leave Type empty and
+// may set a Channel. Replies and reactions reference the message they belong
+// to via ParentID and inherit its channel.
 type Transaction struct {
-	Sender  string `json:"sender"`
-	Message string `json:"message"`
-}
-
-// register rollup specific handler
-func registerHandlers(a *App) {
-	a.restRouter.HandleFunc("/message", a.postMessage).Methods("POST")
-	a.restRouter.HandleFunc("/recent", a.getRecentMessages).Methods("GET")
+	Sender   string `json:"sender"`
+	Message  string `json:"message"`
+	Type     TxType `json:"type,omitempty"`
+	ParentID string `json:"parentId,omitempty"`
+	Reaction string `json:"reaction,omitempty"`
+	PowNonce uint64 `json:"powNonce,omitempty"`
+	Channel  string `json:"channel,omitempty"`
 }
 
-// encode transaction into bytes to be sent to the sequencer
-func encodeTx(tx Transaction) ([]byte, error) {
-	data, err := json.Marshal(tx)
-	if err != nil {
-		log.Errorf("error encoding transaction: %s\n", err)
-		return nil, err
+// Kind returns the transaction type, treating an empty type as a message.
+func (tx *Transaction) Kind() TxType {
+	if tx.Type == "" {
+		return TxTypeMessage
 	}
-	return data, nil
+	return tx.Type
 }
 
-// decode transaction from bytes back into rollup format
-func decodeTx(txEncoded []byte) (*Transaction, error) {
-	tx := &Transaction{}
-	if err := json.Unmarshal(txEncoded, tx); err != nil {
-		log.Errorf("error decoding transaction: %s\n", err)
-		return nil, err
+// DefaultChannel is the channel of messages which don't set one.
+const DefaultChannel = "general"
+
+// ChannelName returns the transaction's channel, or DefaultChannel if none
+// is set.
+func (tx *Transaction) ChannelName() string {
+	if tx.Channel == "" {
+		return DefaultChannel
 	}
-	return tx, nil
+	return tx.Channel
 }
 
-// create starting block for this rollup
-func GenesisTransaction() []byte {
-	genesisTx := Transaction{
-		Sender:  "astria",
-		Message: "hello, world!",
-	}
-	encodedTx, err := encodeTx(genesisTx)
-	if err != nil {
-		log.Errorf("error encoding genesis tx: %s\n", err)
-		panic(err)
-	}
-	return encodedTx
+// register rollup specific handler
+func registerHandlers(a *App) {
+	// authenticate before rate limiting, so that unauthenticated callers
+	// can't use up the bucket of a sender they claim to be
+	a.restRouter.HandleFunc("/message", a.withScope(ScopeMessagesWrite, a.rateLimiter.Middleware(http.HandlerFunc(a.postMessage)).ServeHTTP)).Methods("POST")
+	a.restRouter.HandleFunc("/recent", a.withScope(ScopeRead, a.getRecentMessages)).Methods("GET")
+	a.restRouter.HandleFunc("/messages", a.withScope(ScopeRead, a.getMessages)).Methods("GET")
+	a.restRouter.HandleFunc("/search", a.withScope(ScopeRead, a.searchMessages)).Methods("GET")
+	a.restRouter.HandleFunc("/messages/{id}/thread", a.withScope(ScopeRead, a.getThread)).Methods("GET")
+	a.restRouter.HandleFunc("/messages/{id}/reactions", a.withScope(ScopeRead, a.getReactions)).Methods("GET")
 }
 
 // send rollup message transaction to the sequencer
 func (a *App) postMessage(w http.ResponseWriter, r *http.Request) {
+	// continue the trace of the caller, if it sent one
+	ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+	ctx, span := tracer.Start(ctx, "App.postMessage", trace.WithSpanKind(trace.SpanKindServer))
+	var err error
+	defer func() { endSpan(span, err) }()
+	logger := requestLog(ctx)
+
 	var tx Transaction
 	// decode transaction to ensure proper format
-	err := json.NewDecoder(r.Body).Decode(&tx)
+	err = json.NewDecoder(r.Body).Decode(&tx)
+	if writeBodyTooLarge(w, err) {
+		logger.Errorf("transaction rejected: %s\n", err)
+		return
+	}
 	if err != nil {
-		log.Errorf("error decoding transaction: %s\n", err)
-		w.WriteHeader(http.StatusBadRequest)
+		logger.Errorf("error decoding transaction: %s\n", err)
+		writeRuleError(w, http.StatusBadRequest, &TxRuleError{Rule: RuleMalformedJSON, Message: err.Error()})
+		return
+	}
+	span.SetAttributes(attribute.String("messenger.tx_type", string(tx.Kind())))
+
+	// pre-check the limits applied when the transaction is executed
+	if err = a.txLimits.CheckTx(&tx); err != nil {
+		logger.Errorf("transaction rejected: %s\n", err)
+		writeRuleError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	// replies and reactions must reference an existing message
+	if err = a.rollupBlocks.State().ValidateTx(&tx); err != nil {
+		logger.Errorf("invalid transaction: %s\n", err)
+		writeRuleError(w, http.StatusBadRequest, &TxRuleError{Rule: RuleInvalidTx, Message: err.Error()})
 		return
 	}
 
 	// recode transaction to send to sequencer
 	txEncoded, err := encodeTx(tx)
 	if err != nil {
-		log.Errorf("error re-encoding transaction: %s\n", err)
+		logger.Errorf("error re-encoding transaction: %s\n", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
 	// send transaction to the sequencer
-	err = a.sequencerClient.SendMessageViaComposer(txEncoded)
+	hash := txHash(txEncoded)
+	span.SetAttributes(attrTxHash.String(hash))
+	logger = logger.WithField(fieldTxHash, hash)
+	err = a.sequencerClient.SendMessageViaComposer(ctx, txEncoded)
 	if err != nil {
-		log.Errorf("error sending message: %s\n", err)
+		logger.Errorf("error sending message: %s\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	log.WithField("result", "success").Debug("transaction submission result")
+	logger.WithField("result", "success").Debug("transaction submission result")
 }
 
-func (a *App) getRecentMessages(w http.ResponseWriter, _ *http.Request) {
-	var messages []Transaction
-	for i := len(a.rollupBlocks.Blocks); i > 0; i-- {
-		block := a.rollupBlocks.Blocks[i-1]
-		if len(block.Txs) > 0 {
-			log.Infof("block txs: %v", block.Txs)
-			for _, txRaw := range block.Txs {
-				log.Infof("raw txn: %v", txRaw)
-				tx, err := decodeTx(txRaw)
-				if err != nil {
-					log.Errorf("tried to decode malformed message: %s\n", err)
-					continue
-				}
-				messages = append(messages, *tx)
-			}
-			if len(messages) >= 100 {
-				break
-			}
-		}
+// writeRuleError responds with the given status and a JSON body describing
+// the rule the submitted transaction failed.
+func writeRuleError(w http.ResponseWriter, status int, err error) {
+	var ruleErr *TxRuleError
+	if !errors.As(err, &ruleErr) {
+		ruleErr = &TxRuleError{Rule: RuleInvalidTx, Message: err.Error()}
+	}
+	body, err := json.Marshal(map[string]*TxRuleError{"error": ruleErr})
+	if err != nil {
+		log.Errorf("error marshalling error response: %s\n", err)
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func (a *App) getRecentMessages(w http.ResponseWriter, r *http.Request) {
+	logger := requestLog(r.Context())
+	page, err := a.rollupBlocks.State().QueryMessages(MessageQuery{
+		Descending: true,
+		Limit:      100,
+	})
+	if err != nil {
+		logger.Errorf("error querying recent messages: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	// keep only the most recent 100
-	if len(messages) > 100 {
-		messages = messages[len(messages)-100:]
+	// return the most recent 100 in chronological order
+	messages := page.Messages
+	for i, j := 0, len(messages)-1; i < j; i, j = i+1, j-1 {
+		messages[i], messages[j] = messages[j], messages[i]
 	}
 
 	messagesJson, err := json.Marshal(messages)
 	if err != nil {
-		log.Errorf("error marshalling messages: %s\n", err)
+		logger.Errorf("error marshalling messages: %s\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -118,30 +174,79 @@ func (a *App) getRecentMessages(w http.ResponseWriter, _ *http.Request) {
 	w.Write(messagesJson)
 }
 
-func prepareBlockForClient(blockTxs [][]byte) []byte {
-	// recode messages as json for front end
-	transactions := []Transaction{}
-	for _, encodedTx := range blockTxs {
-		decodedTx, err := decodeTx(encodedTx)
-		if err != nil {
-			log.Errorf("error decoding tx in block: %v, error: %s\n", encodedTx, err)
-			continue
-		}
-		transactions = append(transactions, *decodedTx)
+// get the reply tree of a message
+func (a *App) getThread(w http.ResponseWriter, r *http.Request) {
+	logger := requestLog(r.Context())
+	id := mux.Vars(r)["id"]
+	thread, err := a.rollupBlocks.State().Thread(id)
+	if err != nil {
+		logger.Errorf("error getting thread for %s: %s\n", id, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	threadJson, err := json.Marshal(thread)
+	if err != nil {
+		logger.Errorf("error marshalling thread: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 
-	// only write blocks with valid transactions
-	if len(transactions) == 0 {
-		return []byte{}
+	w.Write(threadJson)
+}
+
+// get the aggregated reaction counts of a message
+func (a *App) getReactions(w http.ResponseWriter, r *http.Request) {
+	logger := requestLog(r.Context())
+	id := mux.Vars(r)["id"]
+	reactions, err := a.rollupBlocks.State().Reactions(id)
+	if err != nil {
+		logger.Errorf("error getting reactions for %s: %s\n", id, err)
+		w.WriteHeader(http.StatusNotFound)
+		return
 	}
 
-	// send transaction encoded format to frontend
-	txsJson, err := json.Marshal(transactions)
+	reactionsJson, err := json.Marshal(map[string]interface{}{
+		"id":        id,
+		"reactions": reactions,
+	})
 	if err != nil {
-		log.Errorf("Failed to marshal transactions: %v", err)
-		return []byte{}
+		logger.Errorf("error marshalling reactions: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
-	log.Debugf("marshalled txsJson: %s", txsJson)
 
-	return txsJson
+	w.Write(reactionsJson)
+}
+
+// clientTx is a transaction as pushed to websocket clients. Reactions carry
+// the updated reaction counts of the message they were added to.
+type clientTx struct {
+	StoredTx
+	Reactions map[string]int `json:"reactions,omitempty"`
+}
+
+// blockClientTxs returns the transactions of a block which were applied to
+// the state, in the format pushed to clients.
+func blockClientTxs(block Block, state *MessengerState) []clientTx {
+	transactions := []clientTx{}
+	for idx := range block.Txs {
+		tx, ok := state.GetTx(MessageID(block.Height, idx))
+		if !ok {
+			log.WithFields(log.Fields{
+				fieldHeight: block.Height,
+				"index":     idx,
+			}).Debug("skipping tx not applied to state")
+			continue
+		}
+		out := clientTx{StoredTx: *tx}
+		if tx.Kind() == TxTypeReaction {
+			reactions, err := state.Reactions(tx.ParentID)
+			if err == nil {
+				out.Reactions = reactions
+			}
+		}
+		transactions = append(transactions, out)
+	}
+	return transactions
 }
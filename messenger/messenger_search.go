@@ -0,0 +1,166 @@
+package messenger
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// token is a normalized word and its byte offsets in the original text.
+type token struct {
+	term  string
+	start int
+	end   int
+}
+
+// tokenize splits text into lower cased words of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+// Highlight is the byte range [Start, End) of a matched term in a message.
+type Highlight struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// SearchResult is a message matching a search, with the ranges of the
+// matched terms.
+type SearchResult struct {
+	Message    StoredTx    `json:"message"`
+	Highlights []Highlight `json:"highlights"`
+}
+
+// SearchIndex is an in-memory inverted index over message text. It is
+// rebuilt along with the rest of the state when blocks are applied.
+type SearchIndex struct {
+	// postings maps a term to the messages containing it, in chain order
+	postings map[string][]*StoredTx
+	// terms maps a message ID to the offsets of each of its terms
+	terms map[string]map[string][]Highlight
+}
+
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		postings: make(map[string][]*StoredTx),
+		terms:    make(map[string]map[string][]Highlight),
+	}
+}
+
+// add indexes the text of a message or reply.
+func (si *SearchIndex) add(tx *StoredTx) {
+	terms := make(map[string][]Highlight)
+	for _, t := range tokenize(tx.Message) {
+		if _, ok := terms[t.term]; !ok {
+			si.postings[t.term] = append(si.postings[t.term], tx)
+		}
+		terms[t.term] = append(terms[t.term], Highlight{Start: t.start, End: t.end})
+	}
+	si.terms[tx.ID] = terms
+}
+
+// search returns up to limit messages containing every term of the query,
+// newest first.
+func (si *SearchIndex) search(query string, limit int) []SearchResult {
+	var terms []string
+	for _, t := range tokenize(query) {
+		terms = append(terms, t.term)
+	}
+	results := []SearchResult{}
+	if len(terms) == 0 {
+		return results
+	}
+
+	// walk the shortest posting list and check the other terms per message
+	shortest := si.postings[terms[0]]
+	for _, term := range terms[1:] {
+		if postings := si.postings[term]; len(postings) < len(shortest) {
+			shortest = postings
+		}
+	}
+	for i := len(shortest) - 1; i >= 0 && len(results) < limit; i-- {
+		tx := shortest[i]
+		docTerms := si.terms[tx.ID]
+
+		var highlights []Highlight
+		matched := true
+		for _, term := range terms {
+			offsets, ok := docTerms[term]
+			if !ok {
+				matched = false
+				break
+			}
+			highlights = append(highlights, offsets...)
+		}
+		if matched {
+			sort.Slice(highlights, func(i, j int) bool {
+				return highlights[i].Start < highlights[j].Start
+			})
+			results = append(results, SearchResult{
+				Message:    *tx,
+				Highlights: highlights,
+			})
+		}
+	}
+	return results
+}
+
+// Search returns messages and replies containing every word of the query,
+// newest first.
+func (s *MessengerState) Search(query string, limit int) []SearchResult {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.search.search(query, limit)
+}
+
+// search messages by their text
+func (a *App) searchMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query().Get("q")
+	if strings.TrimSpace(query) == "" || !utf8.ValidString(query) {
+		http.Error(w, "q must be a non-empty search query", http.StatusBadRequest)
+		return
+	}
+
+	limit := a.messagesDefaultLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		var err error
+		if limit, err = strconv.Atoi(limitStr); err != nil || limit <= 0 {
+			http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if limit > a.messagesMaxLimit {
+		limit = a.messagesMaxLimit
+	}
+
+	results := a.rollupBlocks.State().Search(query, limit)
+	resultsJson, err := json.Marshal(map[string]interface{}{
+		"query":   query,
+		"results": results,
+	})
+	if err != nil {
+		requestLog(r.Context()).Errorf("error marshalling search results: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(resultsJson)
+}
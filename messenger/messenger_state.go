@@ -0,0 +1,244 @@
+package messenger
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// MessageID returns the identifier of the transaction at the given position
+// in the chain.
+func MessageID(height uint32, index int) string {
+	return fmt.Sprintf("%d-%d", height, index)
+}
+
+// StoredTx is a transaction that has been applied to the messenger state,
+// along with its position in the chain.
+type StoredTx struct {
+	ID        string    `json:"id"`
+	Height    uint32    `json:"height"`
+	Index     int       `json:"index"`
+	Timestamp time.Time `json:"timestamp"`
+	Transaction
+}
+
+// ThreadNode is a message together with its (recursive) replies.
+type ThreadNode struct {
+	StoredTx
+	Replies []*ThreadNode `json:"replies"`
+}
+
+// MessengerState holds the rollup state derived from executed blocks: every
+// applied transaction, the reply tree and the aggregated reactions.
+type MessengerState struct {
+	mu sync.RWMutex
+	// txs indexes every applied transaction by its ID
+	txs map[string]*StoredTx
+	// replies maps a message ID to the IDs of its direct replies, in chain order
+	replies map[string][]string
+	// reactions maps a message ID to reaction -> set of senders
+	reactions map[string]map[string]map[string]struct{}
+	// history indexes messages and replies in chain order, see messenger_history.go
+	history   []*StoredTx
+	bySender  map[string][]*StoredTx
+	byChannel map[string][]*StoredTx
+	// search is the full text index over messages and replies
+	search *SearchIndex
+}
+
+func NewMessengerState() *MessengerState {
+	return &MessengerState{
+		txs:       make(map[string]*StoredTx),
+		replies:   make(map[string][]string),
+		reactions: make(map[string]map[string]map[string]struct{}),
+		bySender:  make(map[string][]*StoredTx),
+		byChannel: make(map[string][]*StoredTx),
+		search:    NewSearchIndex(),
+	}
+}
+
+// StateStats counts what the messenger state holds.
+type StateStats struct {
+	// Txs counts the applied transactions of every type
+	Txs int `json:"txs"`
+	// Messages counts the messages and replies
+	Messages  int `json:"messages"`
+	Senders   int `json:"senders"`
+	Channels  int `json:"channels"`
+	Reactions int `json:"reactions"`
+	// SearchTerms is the number of distinct terms in the search index
+	SearchTerms int `json:"searchTerms"`
+}
+
+// Stats returns the counts of the state.
+func (s *MessengerState) Stats() StateStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := StateStats{
+		Txs:         len(s.txs),
+		Messages:    len(s.history),
+		Senders:     len(s.bySender),
+		Channels:    len(s.byChannel),
+		SearchTerms: len(s.search.postings),
+	}
+	for _, byReaction := range s.reactions {
+		for _, senders := range byReaction {
+			stats.Reactions += len(senders)
+		}
+	}
+	return stats
+}
+
+// ValidateTx checks a transaction against the current state. Replies and
+// reactions must reference an existing message or reply.
+func (s *MessengerState) ValidateTx(tx *Transaction) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.validateTx(tx)
+}
+
+func (s *MessengerState) validateTx(tx *Transaction) error {
+	switch tx.Kind() {
+	case TxTypeMessage:
+		if tx.ParentID != "" || tx.Reaction != "" {
+			return errors.New("message must not have a parent or reaction")
+		}
+		return nil
+	case TxTypeReply:
+		if tx.Message == "" {
+			return errors.New("reply must have a message")
+		}
+	case TxTypeReaction:
+		if tx.Reaction == "" {
+			return errors.New("reaction must not be empty")
+		}
+	default:
+		return fmt.Errorf("unknown transaction type: %s", tx.Type)
+	}
+	if tx.Channel != "" {
+		return errors.New("replies and reactions use the channel of their parent")
+	}
+
+	parent, ok := s.txs[tx.ParentID]
+	if !ok {
+		return fmt.Errorf("parent message %q not found", tx.ParentID)
+	}
+	if parent.Kind() == TxTypeReaction {
+		return errors.New("parent must be a message or reply")
+	}
+	return nil
+}
+
+// ApplyBlock applies every valid transaction in the block to the state.
+// Invalid transactions stay in the block but have no effect on the state.
+func (s *MessengerState) ApplyBlock(block *Block) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for idx, txRaw := range block.Txs {
+		tx, err := decodeTx(txRaw)
+		if err != nil {
+			continue
+		}
+		if err := s.applyTx(block, idx, tx); err != nil {
+			log.WithFields(log.Fields{
+				fieldHeight: block.Height,
+				"index":     idx,
+			}).Debugf("skipping invalid tx: %s", err)
+		}
+	}
+}
+
+// ApplyTx applies the transaction at index idx of a block to the state,
+// returning why it is invalid if it is.
+func (s *MessengerState) ApplyTx(block *Block, idx int, tx *Transaction) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.applyTx(block, idx, tx)
+}
+
+// applyTx applies a transaction if it is valid. The caller must hold mu.
+func (s *MessengerState) applyTx(block *Block, idx int, tx *Transaction) error {
+	if err := s.validateTx(tx); err != nil {
+		return err
+	}
+
+	stored := &StoredTx{
+		ID:          MessageID(block.Height, idx),
+		Height:      block.Height,
+		Index:       idx,
+		Timestamp:   block.Timestamp,
+		Transaction: *tx,
+	}
+	if tx.Kind() != TxTypeMessage {
+		stored.Channel = s.txs[tx.ParentID].Channel
+	}
+	s.txs[stored.ID] = stored
+
+	switch tx.Kind() {
+	case TxTypeMessage:
+		s.indexHistory(stored)
+	case TxTypeReply:
+		s.replies[tx.ParentID] = append(s.replies[tx.ParentID], stored.ID)
+		s.indexHistory(stored)
+	case TxTypeReaction:
+		if s.reactions[tx.ParentID] == nil {
+			s.reactions[tx.ParentID] = make(map[string]map[string]struct{})
+		}
+		if s.reactions[tx.ParentID][tx.Reaction] == nil {
+			s.reactions[tx.ParentID][tx.Reaction] = make(map[string]struct{})
+		}
+		s.reactions[tx.ParentID][tx.Reaction][tx.Sender] = struct{}{}
+	}
+	return nil
+}
+
+// GetTx returns the applied transaction with the given ID.
+func (s *MessengerState) GetTx(id string) (*StoredTx, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	tx, ok := s.txs[id]
+	return tx, ok
+}
+
+// Thread returns the reply tree rooted at the given message.
+func (s *MessengerState) Thread(id string) (*ThreadNode, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	root, ok := s.txs[id]
+	if !ok || root.Kind() == TxTypeReaction {
+		return nil, errors.New("message not found")
+	}
+	return s.thread(root), nil
+}
+
+func (s *MessengerState) thread(tx *StoredTx) *ThreadNode {
+	node := &ThreadNode{
+		StoredTx: *tx,
+		Replies:  []*ThreadNode{},
+	}
+	for _, replyID := range s.replies[tx.ID] {
+		node.Replies = append(node.Replies, s.thread(s.txs[replyID]))
+	}
+	return node
+}
+
+// Reactions returns the number of distinct senders per reaction on the
+// given message.
+func (s *MessengerState) Reactions(id string) (map[string]int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if tx, ok := s.txs[id]; !ok || tx.Kind() == TxTypeReaction {
+		return nil, errors.New("message not found")
+	}
+	counts := make(map[string]int)
+	for reaction, senders := range s.reactions[id] {
+		counts[reaction] = len(senders)
+	}
+	return counts, nil
+}
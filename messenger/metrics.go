@@ -0,0 +1,199 @@
+package messenger
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Prometheus metrics, served on /metrics. The collectors are shared by the
+// package and registered on a registry per app.
+var (
+	blockHeight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "messenger",
+		Name:      "block_height",
+		Help:      "Height of the latest block by commitment level: executed, soft or firm.",
+	}, []string{"commitment"})
+	executeBlockDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "messenger",
+		Name:      "execute_block_duration_seconds",
+		Help:      "Time taken by ExecuteBlock.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 14),
+	})
+	executedTxs = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "messenger",
+		Name:      "executed_txs_total",
+		Help:      "Transactions included in executed blocks.",
+	})
+	skippedTxs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "messenger",
+		Name:      "skipped_txs_total",
+		Help:      "Sequenced transactions left out of executed blocks, by reason: deposit, decode or the failed rule.",
+	}, []string{"reason"})
+	txDecodeFailures = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "messenger",
+		Name:      "tx_decode_failures_total",
+		Help:      "Sequenced transactions which could not be decoded.",
+	})
+
+	composerSubmissions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "messenger",
+		Name:      "composer_submissions_total",
+		Help:      "Transactions submitted to the composer, by result: success or failure.",
+	}, []string{"result"})
+	composerSubmissionDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "messenger",
+		Name:      "composer_submission_duration_seconds",
+		Help:      "Time taken to submit a transaction to the composer.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	eventClients = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "messenger",
+		Name:      "event_clients",
+		Help:      "Connected event clients, by transport: ws, sse, poll, webhook or grpc.",
+	}, []string{"transport"})
+	eventsDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "messenger",
+		Name:      "events_dropped_total",
+		Help:      "Events not pushed to a client because its queue was full, by transport and event type.",
+	}, []string{"transport", "type"})
+	eventClientCatchUps = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "messenger",
+		Name:      "event_client_catch_ups_total",
+		Help:      "Times a client with a full queue was switched to catching up from storage, by transport.",
+	}, []string{"transport"})
+	eventClientDisconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "messenger",
+		Name:      "event_client_disconnects_total",
+		Help:      "Clients disconnected for lagging behind, being too slow or by an admin, by transport.",
+	}, []string{"transport"})
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "messenger",
+		Name:      "http_requests_total",
+		Help:      "REST API requests, by method, route and status code.",
+	}, []string{"method", "route", "code"})
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "messenger",
+		Name:      "http_request_duration_seconds",
+		Help:      "REST API request latency, by method and route. Long-lived /ws, /events and /poll requests are left out.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+// newMetricsRegistry returns a registry with the package metrics, the Go
+// and process collectors and the app's rate limiter rejections.
+func newMetricsRegistry(rateLimiter *RateLimiter) *prometheus.Registry {
+	// export the heights before the first block is executed
+	for _, level := range []CommitmentLevel{CommitmentExecuted, CommitmentSoft, CommitmentFirm} {
+		blockHeight.WithLabelValues(string(level))
+	}
+
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		blockHeight,
+		executeBlockDuration,
+		executedTxs,
+		skippedTxs,
+		txDecodeFailures,
+		composerSubmissions,
+		composerSubmissionDuration,
+		eventClients,
+		eventsDropped,
+		eventClientCatchUps,
+		eventClientDisconnects,
+		httpRequests,
+		httpRequestDuration,
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   "messenger",
+			Name:        "rate_limited_submissions_total",
+			Help:        "Submissions rejected by the rate limits, by limit.",
+			ConstLabels: prometheus.Labels{"limit": "ip"},
+		}, func() float64 {
+			byIP, _ := rateLimiter.Rejected()
+			return float64(byIP)
+		}),
+		prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace:   "messenger",
+			Name:        "rate_limited_submissions_total",
+			Help:        "Submissions rejected by the rate limits, by limit.",
+			ConstLabels: prometheus.Labels{"limit": "sender"},
+		}, func() float64 {
+			_, bySender := rateLimiter.Rejected()
+			return float64(bySender)
+		}),
+	)
+	return reg
+}
+
+// metricsHandler serves the metrics of a registry.
+func metricsHandler(reg *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(reg, promhttp.HandlerOpts{Registry: reg})
+}
+
+// streamingRoutes are routes whose requests last as long as the client is
+// connected, so their duration is not recorded.
+var streamingRoutes = map[string]bool{
+	"/ws":     true,
+	"/events": true,
+	"/poll":   true,
+}
+
+// httpMetricsMiddleware records the count and latency of REST requests by
+// route template, so that path parameters don't create new series.
+func httpMetricsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := "unknown"
+		if current := mux.CurrentRoute(r); current != nil {
+			if template, err := current.GetPathTemplate(); err == nil {
+				route = template
+			}
+		}
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+
+		httpRequests.WithLabelValues(r.Method, route, strconv.Itoa(rec.status)).Inc()
+		if !streamingRoutes[route] {
+			httpRequestDuration.WithLabelValues(r.Method, route).Observe(time.Since(start).Seconds())
+		}
+	})
+}
+
+// statusRecorder records the status code written to a response. It passes
+// flushes and hijacks through for the SSE and WebSocket handlers.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not support hijacking")
+	}
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
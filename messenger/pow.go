@@ -0,0 +1,56 @@
+package messenger
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math/bits"
+)
+
+// PowDigest returns the digest a transaction's proof of work is computed
+// over: sha256 of the type, channel, sender, parent ID, reaction and message,
+// each terminated by a zero byte, followed by the nonce as 8 big-endian bytes.
+//
+// It is independent of the on chain encoding so that clients can solve the
+// proof of work without reproducing the transaction envelope.
+func PowDigest(tx *Transaction) [32]byte {
+	h := sha256.New()
+	for _, field := range []string{string(tx.Kind()), tx.Channel, tx.Sender, tx.ParentID, tx.Reaction, tx.Message} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	var nonce [8]byte
+	binary.BigEndian.PutUint64(nonce[:], tx.PowNonce)
+	h.Write(nonce[:])
+
+	var digest [32]byte
+	copy(digest[:], h.Sum(nil))
+	return digest
+}
+
+// leadingZeroBits returns the number of leading zero bits of the digest.
+func leadingZeroBits(digest [32]byte) int {
+	n := 0
+	for _, b := range digest {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
+
+// CheckPow reports whether the transaction's nonce solves a proof of work of
+// the given difficulty, in leading zero bits of its PowDigest.
+func CheckPow(tx *Transaction, difficulty int) bool {
+	if difficulty <= 0 {
+		return true
+	}
+	return leadingZeroBits(PowDigest(tx)) >= difficulty
+}
+
+// SolvePow searches for a nonce solving a proof of work of the given
+// difficulty and sets it on the transaction.
+func SolvePow(tx *Transaction, difficulty int) {
+	for tx.PowNonce = 0; !CheckPow(tx, difficulty); tx.PowNonce++ {
+	}
+}
@@ -0,0 +1,267 @@
+package messenger
+
+import (
+	"context"
+	"encoding/hex"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	messengerv1 "github.com/astriaorg/messenger-rollup/gen/messenger/v1"
+)
+
+// MessengerQueryServiceServer implements the MessengerQueryService, giving
+// typed read access to the rollup over gRPC. It is served alongside the
+// execution service.
+type MessengerQueryServiceServer struct {
+	messengerv1.UnimplementedMessengerQueryServiceServer
+	rollupBlocks *RollupBlocks
+	eventHub     *EventHub
+	// page sizes of GetMessages
+	defaultLimit int
+	maxLimit     int
+}
+
+// NewMessengerQueryServiceServer creates a new MessengerQueryServiceServer.
+func NewMessengerQueryServiceServer(rollupBlocks *RollupBlocks, eventHub *EventHub, defaultLimit int, maxLimit int) *MessengerQueryServiceServer {
+	return &MessengerQueryServiceServer{
+		rollupBlocks: rollupBlocks,
+		eventHub:     eventHub,
+		defaultLimit: defaultLimit,
+		maxLimit:     maxLimit,
+	}
+}
+
+// commitmentFromPb converts a protobuf commitment level, unspecified being
+// the empty level.
+func commitmentFromPb(level messengerv1.CommitmentLevel) (CommitmentLevel, error) {
+	switch level {
+	case messengerv1.CommitmentLevel_COMMITMENT_LEVEL_UNSPECIFIED:
+		return "", nil
+	case messengerv1.CommitmentLevel_COMMITMENT_LEVEL_EXECUTED:
+		return CommitmentExecuted, nil
+	case messengerv1.CommitmentLevel_COMMITMENT_LEVEL_SOFT:
+		return CommitmentSoft, nil
+	case messengerv1.CommitmentLevel_COMMITMENT_LEVEL_FIRM:
+		return CommitmentFirm, nil
+	}
+	return "", status.Errorf(codes.InvalidArgument, "unknown commitment level: %s", level)
+}
+
+func commitmentToPb(level CommitmentLevel) messengerv1.CommitmentLevel {
+	switch level {
+	case CommitmentExecuted:
+		return messengerv1.CommitmentLevel_COMMITMENT_LEVEL_EXECUTED
+	case CommitmentSoft:
+		return messengerv1.CommitmentLevel_COMMITMENT_LEVEL_SOFT
+	case CommitmentFirm:
+		return messengerv1.CommitmentLevel_COMMITMENT_LEVEL_FIRM
+	}
+	return messengerv1.CommitmentLevel_COMMITMENT_LEVEL_UNSPECIFIED
+}
+
+func txTypeToPb(kind TxType) messengerv1.TxType {
+	switch kind {
+	case TxTypeMessage:
+		return messengerv1.TxType_TX_TYPE_MESSAGE
+	case TxTypeReply:
+		return messengerv1.TxType_TX_TYPE_REPLY
+	case TxTypeReaction:
+		return messengerv1.TxType_TX_TYPE_REACTION
+	}
+	return messengerv1.TxType_TX_TYPE_UNSPECIFIED
+}
+
+// storedTxToPb converts a stored transaction and, if set, reaction counts.
+func storedTxToPb(tx *StoredTx, reactions map[string]int) *messengerv1.StoredTransaction {
+	res := &messengerv1.StoredTransaction{
+		Id:        tx.ID,
+		Height:    tx.Height,
+		Index:     uint32(tx.Index),
+		Timestamp: timestamppb.New(tx.Timestamp),
+		Type:      txTypeToPb(tx.Kind()),
+		Sender:    tx.Sender,
+		Message:   tx.Message,
+		ParentId:  tx.ParentID,
+		Reaction:  tx.Reaction,
+		Channel:   tx.ChannelName(),
+	}
+	if len(reactions) > 0 {
+		res.Reactions = make(map[string]uint32, len(reactions))
+		for reaction, count := range reactions {
+			res.Reactions[reaction] = uint32(count)
+		}
+	}
+	return res
+}
+
+// GetMessages returns a page of messages and replies.
+func (s *MessengerQueryServiceServer) GetMessages(ctx context.Context, req *messengerv1.GetMessagesRequest) (*messengerv1.GetMessagesResponse, error) {
+	requestLog(ctx).WithField("request", req).Debug("GetMessages called")
+	q := MessageQuery{
+		Cursor:     req.Cursor,
+		Sender:     req.Sender,
+		Channel:    req.Channel,
+		Descending: !req.Ascending,
+		Limit:      s.defaultLimit,
+	}
+	if req.Since != nil {
+		q.Since = req.Since.AsTime()
+	}
+	if req.Until != nil {
+		q.Until = req.Until.AsTime()
+	}
+	if req.Limit > 0 {
+		q.Limit = int(req.Limit)
+	}
+	if q.Limit > s.maxLimit {
+		q.Limit = s.maxLimit
+	}
+
+	page, err := s.rollupBlocks.State().QueryMessages(q)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	res := &messengerv1.GetMessagesResponse{
+		Messages:   make([]*messengerv1.StoredTransaction, 0, len(page.Messages)),
+		NextCursor: page.NextCursor,
+	}
+	for i := range page.Messages {
+		res.Messages = append(res.Messages, storedTxToPb(&page.Messages[i], nil))
+	}
+	return res, nil
+}
+
+// GetBlock returns a block by height, or the latest block at a commitment
+// level.
+func (s *MessengerQueryServiceServer) GetBlock(ctx context.Context, req *messengerv1.GetBlockRequest) (*messengerv1.Block, error) {
+	requestLog(ctx).WithField("request", req).Debug("GetBlock called")
+	var height uint32
+	switch identifier := req.Identifier.(type) {
+	case *messengerv1.GetBlockRequest_Height:
+		height = identifier.Height
+	case *messengerv1.GetBlockRequest_Commitment:
+		level, err := commitmentFromPb(identifier.Commitment)
+		if err != nil {
+			return nil, err
+		}
+		if level == "" {
+			level = CommitmentExecuted
+		}
+		height = s.rollupBlocks.CommittedHeight(level)
+	default:
+		return nil, status.Error(codes.InvalidArgument, "block height or commitment required")
+	}
+
+	block, err := s.rollupBlocks.GetSingleBlock(height)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "block %d not found", height)
+	}
+	res := &messengerv1.Block{
+		Height:       block.Height,
+		Hash:         block.Hash[:],
+		ParentHash:   block.ParentHash[:],
+		Timestamp:    timestamppb.New(block.Timestamp),
+		Txs:          block.Txs,
+		Transactions: []*messengerv1.StoredTransaction{},
+	}
+	for _, tx := range blockClientTxs(*block, s.rollupBlocks.State()) {
+		res.Transactions = append(res.Transactions, storedTxToPb(&tx.StoredTx, nil))
+	}
+	return res, nil
+}
+
+// GetTx returns a transaction by id, with its reaction counts.
+func (s *MessengerQueryServiceServer) GetTx(ctx context.Context, req *messengerv1.GetTxRequest) (*messengerv1.StoredTransaction, error) {
+	requestLog(ctx).WithField("id", req.Id).Debug("GetTx called")
+	// the transaction and its reactions are read from the same state
+	state := s.rollupBlocks.State()
+	tx, ok := state.GetTx(req.Id)
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "transaction %s not found", req.Id)
+	}
+	var reactions map[string]int
+	if tx.Kind() != TxTypeReaction {
+		reactions, _ = state.Reactions(tx.ID)
+	}
+	return storedTxToPb(tx, reactions), nil
+}
+
+// SubscribeMessages streams the matching transactions of blocks reaching the
+// requested commitment level, after replaying from from_height if set. The
+// subscriber is an event client of the same hub as the WebSocket clients,
+// and is disconnected the same way when it lags behind. The stream ends with
+// Unavailable on shutdown, ResourceExhausted if the subscriber was too slow
+// and Aborted if an operator disconnected it.
+func (s *MessengerQueryServiceServer) SubscribeMessages(req *messengerv1.SubscribeMessagesRequest, stream messengerv1.MessengerQueryService_SubscribeMessagesServer) error {
+	logger := requestLog(stream.Context())
+	logger.WithField("request", req).Debug("SubscribeMessages called")
+	level, err := commitmentFromPb(req.Commitment)
+	if err != nil {
+		return err
+	}
+
+	addr := ""
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		addr = p.Addr.String()
+	}
+	transport := newHandlerTransport("grpc", addr)
+	client := NewEventClient(transport, s.eventHub)
+	if _, err := client.subscribe(req.Channels, req.Senders, level); err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if req.FromHeight != nil {
+		s.eventHub.RegisterFrom(client, *req.FromHeight)
+	} else {
+		s.eventHub.Register(client)
+	}
+	defer func() {
+		close(client.done)
+		s.eventHub.Unregister(client)
+	}()
+
+	for {
+		select {
+		case event := <-client.egress:
+			if event.Type != WSEventTransactions {
+				continue
+			}
+			txs, ok := event.Data.([]clientTx)
+			if !ok {
+				continue
+			}
+			hash, err := hex.DecodeString(event.Hash)
+			if err != nil {
+				return status.Error(codes.Internal, err.Error())
+			}
+			res := &messengerv1.SubscribeMessagesResponse{
+				Height:       event.Height,
+				Hash:         hash,
+				Commitment:   commitmentToPb(event.Commitment),
+				Transactions: make([]*messengerv1.StoredTransaction, 0, len(txs)),
+			}
+			for i := range txs {
+				res.Transactions = append(res.Transactions, storedTxToPb(&txs[i].StoredTx, txs[i].Reactions))
+			}
+			if err := stream.Send(res); err != nil {
+				logger.Errorf("error sending to grpc subscriber: %v", err)
+				return err
+			}
+		case <-transport.closed:
+			switch {
+			case transport.reason == reasonShutdown:
+				return status.Error(codes.Unavailable, transport.reason)
+			case client.slow.Load():
+				return status.Error(codes.ResourceExhausted, transport.reason)
+			case transport.reason != "":
+				return status.Error(codes.Aborted, transport.reason)
+			}
+			return nil
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package messenger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// bucketIdleTimeout is how long a full bucket is kept before it is pruned.
+const bucketIdleTimeout = 10 * time.Minute
+
+// tokenBucket is a token bucket refilled continuously at rate tokens per
+// second, holding at most burst tokens.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// bucketLimiter keeps one token bucket per key.
+type bucketLimiter struct {
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastPrune time.Time
+	sync.Mutex
+}
+
+func newBucketLimiter(perMinute int, burst int) *bucketLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &bucketLimiter{
+		rate:      float64(perMinute) / 60,
+		burst:     float64(burst),
+		buckets:   make(map[string]*tokenBucket),
+		lastPrune: time.Now(),
+	}
+}
+
+// allow takes a token from the bucket for key. If the bucket is empty it
+// returns false and how long until the next token is available.
+func (l *bucketLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.lastPrune) > bucketIdleTimeout {
+		l.prune(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+
+	if b.tokens < 1 {
+		wait := time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+		return false, wait
+	}
+	b.tokens--
+	return true, 0
+}
+
+// prune drops buckets that have been idle long enough to be full again.
+func (l *bucketLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if now.Sub(b.last) > bucketIdleTimeout {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPrune = now
+}
+
+// RateLimiter limits transaction submissions per client IP and per sender.
+type RateLimiter struct {
+	byIP              *bucketLimiter
+	bySender          *bucketLimiter
+	trustProxyHeaders bool
+	// maxBodyBytes bounds the submission bodies read to find the sender
+	maxBodyBytes int64
+
+	rejectedIP     atomic.Uint64
+	rejectedSender atomic.Uint64
+}
+
+// NewRateLimiter creates a RateLimiter. A rate of 0 disables that limit.
+// Submission bodies larger than maxBodyBytes are rejected.
+func NewRateLimiter(ipPerMinute, ipBurst, senderPerMinute, senderBurst int, trustProxyHeaders bool, maxBodyBytes int64) *RateLimiter {
+	return &RateLimiter{
+		byIP:              newBucketLimiter(ipPerMinute, ipBurst),
+		bySender:          newBucketLimiter(senderPerMinute, senderBurst),
+		trustProxyHeaders: trustProxyHeaders,
+		maxBodyBytes:      maxBodyBytes,
+	}
+}
+
+// Rejected returns the number of submissions rejected by the IP and sender
+// limits.
+func (rl *RateLimiter) Rejected() (byIP uint64, bySender uint64) {
+	return rl.rejectedIP.Load(), rl.rejectedSender.Load()
+}
+
+// Middleware rate limits the transaction submissions of the handler it
+// wraps, and bounds their bodies to maxBodyBytes.
+func (rl *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, rl.maxBodyBytes)
+		now := time.Now()
+
+		ip := rl.clientIP(r)
+		if rl.byIP != nil {
+			if ok, wait := rl.byIP.allow(ip, now); !ok {
+				rl.rejectedIP.Add(1)
+				log.WithFields(log.Fields{
+					"ip":   ip,
+					"path": r.URL.Path,
+				}).Warn("submission rejected, ip rate limit exceeded")
+				writeRateLimited(w, wait, "too many submissions from this address")
+				return
+			}
+		}
+
+		if rl.bySender != nil {
+			sender, err := peekSender(r)
+			if writeBodyTooLarge(w, err) {
+				log.WithFields(log.Fields{
+					"ip":   ip,
+					"path": r.URL.Path,
+				}).Warn("submission rejected, body too large")
+				return
+			}
+			if err != nil {
+				// leave malformed bodies to the handler
+				next.ServeHTTP(w, r)
+				return
+			}
+			if ok, wait := rl.bySender.allow(sender, now); !ok {
+				rl.rejectedSender.Add(1)
+				log.WithFields(log.Fields{
+					"ip":     ip,
+					"sender": sender,
+					"path":   r.URL.Path,
+				}).Warn("submission rejected, sender rate limit exceeded")
+				writeRateLimited(w, wait, "too many submissions from this sender")
+				return
+			}
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// clientIP returns the address the request is limited by. Behind a trusted
+// proxy that is the last X-Forwarded-For entry, the one the proxy appended:
+// the entries before it are sent by the client and can't be trusted.
+func (rl *RateLimiter) clientIP(r *http.Request) string {
+	if rl.trustProxyHeaders {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			last := forwarded[len(forwarded)-1]
+			if i := strings.LastIndex(last, ","); i >= 0 {
+				last = last[i+1:]
+			}
+			if ip := strings.TrimSpace(last); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// peekSender reads the sender from a JSON request body, leaving the body in
+// place for the handler.
+func peekSender(r *http.Request) (string, error) {
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	var tx struct {
+		Sender string `json:"sender"`
+	}
+	if err := json.Unmarshal(body, &tx); err != nil {
+		return "", err
+	}
+	return tx.Sender, nil
+}
+
+// writeBodyTooLarge writes a 413 if err is a body read exceeding the
+// maxBodyBytes bound, and returns whether it did.
+func writeBodyTooLarge(w http.ResponseWriter, err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	if !errors.As(err, &maxBytesErr) {
+		return false
+	}
+	writeRuleError(w, http.StatusRequestEntityTooLarge, ruleError(RuleMaxBodyBytes, "body exceeds %d bytes", maxBytesErr.Limit))
+	return true
+}
+
+func writeRateLimited(w http.ResponseWriter, wait time.Duration, message string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	writeRuleError(w, http.StatusTooManyRequests, &TxRuleError{Rule: RuleRateLimited, Message: message})
+}
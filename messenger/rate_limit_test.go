@@ -0,0 +1,55 @@
+package messenger
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// submit posts a transaction of sender through the rate limiter from the
+// proxy, with the X-Forwarded-For header set if forwarded isn't empty.
+func submit(handler http.Handler, forwarded string, sender string) int {
+	req := httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(`{"sender":"`+sender+`","message":"hi"}`))
+	req.RemoteAddr = "10.0.0.1:1234"
+	if forwarded != "" {
+		req.Header.Set("X-Forwarded-For", forwarded)
+	}
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestRateLimiterIgnoresSpoofedForwardedFor(t *testing.T) {
+	rl := NewRateLimiter(1, 2, 0, 0, true, 1<<20)
+	handler := rl.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	// the client rotates the leftmost entry, the proxy appends its address
+	for i, want := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		spoofed := fmt.Sprintf("198.51.100.%d", i)
+		if code := submit(handler, spoofed+", 203.0.113.7", "alice"); code != want {
+			t.Errorf("submission %d: status %d, want %d", i, code, want)
+		}
+	}
+	// another client behind the proxy has its own bucket
+	if code := submit(handler, "203.0.113.8", "alice"); code != http.StatusOK {
+		t.Errorf("other client: status %d, want %d", code, http.StatusOK)
+	}
+}
+
+func TestOversizedSubmissionsRejected(t *testing.T) {
+	body := `{"sender":"alice","message":"` + strings.Repeat("a", 100) + `"}`
+	for _, senderPerMinute := range []int{0, 60} {
+		rl := NewRateLimiter(0, 0, senderPerMinute, 5, false, 64)
+		handler := rl.Middleware(http.HandlerFunc((&App{}).postMessage))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/message", strings.NewReader(body)))
+		if rec.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("sender limit %d: status %d, want %d", senderPerMinute, rec.Code, http.StatusRequestEntityTooLarge)
+		}
+		if !strings.Contains(rec.Body.String(), RuleMaxBodyBytes) {
+			t.Errorf("sender limit %d: body %s, want rule %s", senderPerMinute, rec.Body, RuleMaxBodyBytes)
+		}
+	}
+}
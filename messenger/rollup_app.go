@@ -2,43 +2,35 @@ package messenger
 
 import (
 	"context"
-	"crypto/ed25519"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"strconv"
-	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
 	astriaGrpc "buf.build/gen/go/astria/execution-apis/grpc/go/astria/execution/v1alpha2/executionv1alpha2grpc"
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/cors"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
-)
-
-var wsUpgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all connections
-	},
-}
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 
-type Config struct {
-	SequencerRPC string `env:"SEQUENCER_RPC, required"`
-	ConductorRPC string `env:"CONDUCTOR_RPC, required"`
-	ComposerRpc  string `env:"COMPOSER_RPC, required"`
-	RESTApiPort  string `env:"RESTAPI_PORT, required"`
-	RollupName   string `env:"ROLLUP_NAME, required"`
-	SeqPrivate   string `env:"SEQUENCER_PRIVATE, required"`
-}
+	messengerv1 "github.com/astriaorg/messenger-rollup/gen/messenger/v1"
+)
 
 // App is the main application struct, containing all the necessary components.
 type App struct {
@@ -50,87 +42,299 @@ type App struct {
 	rollupBlocks    *RollupBlocks
 	rollupName      string
 	rollupID        []byte
-	newBlockChan    chan Block
-	wsClients       WSClientList
-	sync.RWMutex
+	genesis         *Genesis
+	txLimits        TxLimits
+	rateLimiter     *RateLimiter
+	eventHub        *EventHub
+	webhooks        *WebhookRegistry
+	metrics         *prometheus.Registry
+	executionServer *ExecutionServiceServerV1Alpha2
+	// executionServing is set while the execution api is serving
+	executionServing atomic.Bool
+	readinessLimits  ReadinessLimits
+	startedAt        time.Time
+	// shutdownTimeout bounds the graceful shutdown
+	shutdownTimeout time.Duration
+	// adminToken enables the admin API if set
+	adminToken string
+	// authenticator checks API credentials, it is nil if authentication is
+	// disabled
+	authenticator Authenticator
+	authReads     bool
+	// cors applies the allowed origins to the REST API and WebSockets
+	cors       *cors.Cors
+	wsUpgrader websocket.Upgrader
+	// serverTLS serves the REST and gRPC APIs over TLS, it is nil if TLS is
+	// disabled
+	serverTLS *serverTLS
+	// tlsReloaders are the TLS files checked for changes every
+	// tlsReloadInterval
+	tlsReloaders      []reloader
+	tlsReloadInterval time.Duration
+	// shutdownTracing flushes the spans not exported yet
+	shutdownTracing func(ctx context.Context) error
+	// page sizes of the message history endpoint
+	messagesDefaultLimit int
+	messagesMaxLimit     int
 }
 
-func NewApp(cfg Config) *App {
-	log.Debugf("Creating new messenger app with config: %v", cfg)
+// NewApp creates an app from a validated config, loading the sequencer key,
+// TLS certificates and other files it refers to.
+func NewApp(ctx context.Context, cfg Config) (*App, error) {
+	txLimits := TxLimits{
+		MaxMessageBytes:  cfg.MaxMessageBytes,
+		MaxSenderLength:  cfg.MaxSenderLength,
+		MaxChannelLength: cfg.MaxChannelLength,
+		MaxTxsPerBlock:   cfg.MaxTxsPerBlock,
+		PowDifficulty:    cfg.PowDifficulty,
+	}
+
+	genesis := DefaultGenesis(cfg.RollupName)
+	if cfg.GenesisFile != "" {
+		var err error
+		if genesis, err = LoadGenesisFile(cfg.GenesisFile); err != nil {
+			return nil, fmt.Errorf("genesis: %w", err)
+		}
+		if genesis.RollupName != cfg.RollupName {
+			return nil, fmt.Errorf("genesis: rollup name %q does not match ROLLUP_NAME %q", genesis.RollupName, cfg.RollupName)
+		}
+	}
+	// the genesis messages must be valid transactions, which the state would
+	// otherwise skip
+	if err := genesis.CheckTxs(txLimits); err != nil {
+		return nil, fmt.Errorf("genesis: %w", err)
+	}
+	genesisBlock, err := genesis.Block()
+	if err != nil {
+		return nil, fmt.Errorf("genesis: %w", err)
+	}
+	log.WithField(fieldHash, hex.EncodeToString(genesisBlock.Hash[:])).Info("genesis loaded")
 
 	newBlockChan := make(chan Block, 20)
-	rollupBlocks := NewRollupBlocks(newBlockChan)
+	commitmentChan := make(chan Commitment, 20)
+	rollupBlocks := NewRollupBlocks(genesisBlock, newBlockChan, commitmentChan)
 	router := mux.NewRouter()
 
 	rollupID := sha256.Sum256([]byte(cfg.RollupName))
 
-	// sequencer private key
-	privateKeyBytes, err := hex.DecodeString(cfg.SeqPrivate)
+	keys, err := newKeyProvider(ctx, KeyConfig{
+		Seed:                   cfg.SeqPrivate,
+		KeyFile:                cfg.SeqKeyFile,
+		KeystoreFile:           cfg.SeqKeystoreFile,
+		KeystorePassphrase:     cfg.SeqKeystorePassphrase,
+		KeystorePassphraseFile: cfg.SeqKeystorePassphraseFile,
+		SignerSocket:           cfg.SeqSignerSocket,
+		SignerTimeout:          cfg.SeqSignerTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("sequencer key: %w", err)
+	}
+	address := SequencerAddress(keys.PublicKey())
+	log.WithField("address", hex.EncodeToString(address[:])).Info("sequencer key loaded")
+
+	authenticator, err := newAuthenticator(AuthConfig{
+		Mode:          cfg.AuthMode,
+		KeysFile:      cfg.AuthKeysFile,
+		HMACSecret:    cfg.AuthHMACSecret,
+		PublicKeyFile: cfg.AuthJWTPublicKeyFile,
+		Issuer:        cfg.AuthJWTIssuer,
+		Audience:      cfg.AuthJWTAudience,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("auth: %w", err)
+	}
+
+	serverTLS, err := newServerTLS(ServerTLSConfig{
+		CertFile:         cfg.TLSCertFile,
+		KeyFile:          cfg.TLSKeyFile,
+		GRPCClientCAFile: cfg.TLSGRPCClientCAFile,
+	})
 	if err != nil {
-		panic(err)
-	}
-	private := ed25519.NewKeyFromSeed(privateKeyBytes)
-
-	return &App{
-		executionRPC:    cfg.ConductorRPC,
-		sequencerRPC:    cfg.SequencerRPC,
-		sequencerClient: *NewSequencerClient(cfg.SequencerRPC, cfg.ComposerRpc, rollupID[:], private),
-		restRouter:      router,
-		restAddr:        cfg.RESTApiPort,
-		rollupBlocks:    rollupBlocks,
-		rollupName:      cfg.RollupName,
-		rollupID:        rollupID[:],
-		newBlockChan:    newBlockChan,
-		wsClients:       make(WSClientList),
+		return nil, fmt.Errorf("tls: %w", err)
+	}
+	composerCreds, tlsReloaders, err := composerCredentials(ComposerTLSConfig{
+		Enabled:    cfg.ComposerTLS,
+		CAFile:     cfg.ComposerTLSCAFile,
+		CertFile:   cfg.ComposerTLSCertFile,
+		KeyFile:    cfg.ComposerTLSKeyFile,
+		ServerName: cfg.ComposerTLSServerName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("composer tls: %w", err)
+	}
+	if serverTLS != nil {
+		tlsReloaders = append(tlsReloaders, serverTLS.reloaders()...)
+	}
+
+	rateLimiter := NewRateLimiter(
+		cfg.RateLimitIPPerMinute,
+		cfg.RateLimitIPBurst,
+		cfg.RateLimitSenderPerMinute,
+		cfg.RateLimitSenderBurst,
+		cfg.RateLimitTrustProxy,
+		txLimits.MaxBodyBytes(),
+	)
+	eventHub := NewEventHub(rollupBlocks, newBlockChan, commitmentChan, cfg.WSMaxLag, cfg.WSSlowClientTimeout)
+	webhookNetworks, err := ParseNetworks(cfg.WebhookAllowedNetworks)
+	if err != nil {
+		return nil, fmt.Errorf("webhook allowed networks: %w", err)
+	}
+	webhooks := NewWebhookRegistry(eventHub, cfg.WebhookTimeout, cfg.WebhookMaxAttempts, webhookNetworks)
+	if cfg.WebhooksFile != "" {
+		configs, err := LoadWebhooksFile(cfg.WebhooksFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, config := range configs {
+			if _, err := webhooks.Add(config); err != nil {
+				return nil, fmt.Errorf("invalid webhook %s: %w", config.URL, err)
+			}
+		}
+	}
+
+	// set up tracing last, so that it needs no shutdown if anything above
+	// fails, and before the composer client is created, so that its calls
+	// are traced
+	shutdownTracing, err := setupTracing(TracingConfig{
+		Exporter:    cfg.TracingExporter,
+		Endpoint:    cfg.TracingEndpoint,
+		Insecure:    cfg.TracingInsecure,
+		SampleRatio: cfg.TracingSampleRatio,
+		ServiceName: "messenger-rollup",
+	})
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+	sequencerClient, err := NewSequencerClient(cfg.SequencerRPC, cfg.ComposerRpc, composerCreds, rollupID[:], keys)
+	if err != nil {
+		shutdownTracing(ctx)
+		return nil, err
+	}
+
+	app := &App{
+		executionRPC:         cfg.ConductorRPC,
+		sequencerRPC:         cfg.SequencerRPC,
+		sequencerClient:      *sequencerClient,
+		restRouter:           router,
+		restAddr:             cfg.RESTApiPort,
+		rollupBlocks:         rollupBlocks,
+		rollupName:           cfg.RollupName,
+		rollupID:             rollupID[:],
+		genesis:              genesis,
+		txLimits:             txLimits,
+		rateLimiter:          rateLimiter,
+		eventHub:             eventHub,
+		webhooks:             webhooks,
+		metrics:              newMetricsRegistry(rateLimiter),
+		shutdownTimeout:      cfg.ShutdownTimeout,
+		shutdownTracing:      shutdownTracing,
+		adminToken:           cfg.AdminToken,
+		authenticator:        authenticator,
+		authReads:            cfg.AuthReads,
+		cors:                 newCORS(cfg.CORSAllowedOrigins),
+		serverTLS:            serverTLS,
+		tlsReloaders:         tlsReloaders,
+		tlsReloadInterval:    cfg.TLSReloadInterval,
+		messagesDefaultLimit: cfg.MessagesDefaultLimit,
+		messagesMaxLimit:     cfg.MessagesMaxLimit,
+		readinessLimits: ReadinessLimits{
+			MaxExecuteAge: cfg.ReadyMaxExecuteAge,
+			MaxSoftLag:    cfg.ReadyMaxSoftLag,
+			MaxFirmLag:    cfg.ReadyMaxFirmLag,
+		},
 	}
+	app.executionServer = app.makeExecutionServer()
+	app.wsUpgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin:     app.checkWSOrigin,
+	}
+	return app, nil
 }
 
 // makeExecutionServer creates a new ExecutionServiceServer.
 func (a *App) makeExecutionServer() *ExecutionServiceServerV1Alpha2 {
-	return NewExecutionServiceServerV1Alpha2(a.rollupBlocks, a.rollupID)
+	return NewExecutionServiceServerV1Alpha2(a.rollupBlocks, a.rollupID, a.genesis, a.txLimits)
+}
+
+// makeQueryServer creates a new MessengerQueryServiceServer.
+func (a *App) makeQueryServer() *MessengerQueryServiceServer {
+	return NewMessengerQueryServiceServer(a.rollupBlocks, a.eventHub, a.messagesDefaultLimit, a.messagesMaxLimit)
 }
 
 // setupRestRoutes sets up the routes for the REST API.
 func (a *App) setupRestRoutes() {
-	a.restRouter.HandleFunc("/block/{height}", a.getBlock).Methods("GET")
-	a.restRouter.HandleFunc("/ws", a.serveWS)
+	a.restRouter.Use(requestIDMiddleware)
+	a.restRouter.Use(httpMetricsMiddleware)
+	a.restRouter.Handle("/metrics", metricsHandler(a.metrics)).Methods("GET")
+	a.restRouter.HandleFunc("/healthz", a.healthz).Methods("GET")
+	a.restRouter.HandleFunc("/readyz", a.readyz).Methods("GET")
+	a.restRouter.HandleFunc("/block/{height}", a.withScope(ScopeRead, a.getBlock)).Methods("GET")
+	a.restRouter.HandleFunc("/ws", a.withScope(ScopeRead, a.serveWS))
+	a.restRouter.HandleFunc("/events", a.withScope(ScopeRead, a.serveEvents)).Methods("GET")
+	a.restRouter.HandleFunc("/poll", a.withScope(ScopeRead, a.pollEvents)).Methods("GET")
+	a.setupWebhookRoutes()
+	a.setupAdminRoutes()
 	registerHandlers(a)
 }
 
 // makeRestServer creates a new HTTP server for the REST API.
 func (a *App) makeRestServer() *http.Server {
-	return &http.Server{
+	server := &http.Server{
 		Addr:    a.restAddr,
-		Handler: cors.Default().Handler(a.restRouter),
+		Handler: a.cors.Handler(a.restRouter),
 	}
+	if a.serverTLS != nil {
+		server.TLSConfig = a.serverTLS.restConfig()
+	}
+	return server
+}
+
+// newCORS returns the CORS handler of the REST API, allowing the given
+// origins. Credentials are sent as headers rather than cookies, so they are
+// not allowed.
+func newCORS(allowedOrigins []string) *cors.Cors {
+	return cors.New(cors.Options{
+		AllowedOrigins: allowedOrigins,
+		AllowedMethods: []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodHead},
+		AllowedHeaders: []string{"Content-Type", "Authorization", apiKeyHeader, requestIDHeader},
+		ExposedHeaders: []string{requestIDHeader},
+	})
+}
+
+// checkWSOrigin allows WebSocket connections from the origins allowed by
+// the CORS handler, and from non-browser clients, which send no origin.
+func (a *App) checkWSOrigin(r *http.Request) bool {
+	return r.Header.Get("Origin") == "" || a.cors.OriginAllowed(r)
 }
 
 func (a *App) getBlock(w http.ResponseWriter, r *http.Request) {
+	logger := requestLog(r.Context())
 	vars := mux.Vars(r)
 	heightStr, ok := vars["height"]
 	if !ok {
-		log.Errorf("error getting height from request\n")
+		logger.Errorf("error getting height from request\n")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 	height, err := strconv.Atoi(heightStr)
 	if err != nil {
-		log.Errorf("error converting height to int: %s\n", err)
+		logger.Errorf("error converting height to int: %s\n", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	log.Debugf("getting block %d\n", height)
+	logger.WithField(fieldHeight, height).Debug("getting block")
 	block, err := a.rollupBlocks.GetSingleBlock(uint32(height))
 	if err != nil {
-		log.Errorf("error getting block: %s\n", err)
+		logger.Errorf("error getting block: %s\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 
 	blockJson, err := json.Marshal(block)
 	if err != nil {
-		log.Errorf("error marshalling block: %s\n", err)
+		logger.Errorf("error marshalling block: %s\n", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -139,96 +343,171 @@ func (a *App) getBlock(w http.ResponseWriter, r *http.Request) {
 }
 
 func (a *App) serveWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	logger := requestLog(r.Context())
+	// clients reconnecting after a drop resume from the last height they saw
+	fromHeightStr := r.URL.Query().Get("fromHeight")
+	fromHeight, err := strconv.ParseUint(fromHeightStr, 10, 32)
+	if fromHeightStr != "" && err != nil {
+		logger.Errorf("error parsing fromHeight: %s\n", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	conn, err := a.wsUpgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Errorf("Failed to upgrade HTTP to WebSocket: %v", err)
+		logger.Errorf("Failed to upgrade HTTP to WebSocket: %v", err)
 		return
 	}
 
-	client := NewWSClient(conn, a)
-	a.addWSClient(client)
+	client := NewWSClient(conn, a.eventHub)
+	if fromHeightStr != "" {
+		a.eventHub.RegisterFrom(client.EventClient, uint32(fromHeight))
+	} else {
+		a.eventHub.Register(client.EventClient)
+	}
 	go client.WaitForMessages()
-	log.Debug("new ws client connected")
+	go client.ReadMessages()
+	logger.WithField(fieldClientID, client.id).Debug("new ws client connected")
 }
 
-func (a *App) addWSClient(client *WSClient) {
-	a.Lock()
-	defer a.Unlock()
-	a.wsClients[client] = true
-}
+// Run serves the execution, query and REST APIs until ctx is cancelled,
+// SIGINT or SIGTERM is received, or one of the servers fails, then shuts
+// everything down gracefully.
+func (a *App) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-func (a *App) removeWSClient(client *WSClient) {
-	a.Lock()
-	defer a.Unlock()
-	if _, ok := a.wsClients[client]; ok {
-		// close connection
-		client.conn.Close()
-		// remove
-		delete(a.wsClients, client)
+	// listen before starting anything, so that address errors are returned
+	// right away
+	lis, err := net.Listen("tcp", a.executionRPC)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", a.executionRPC, err)
+	}
+	restLis, err := net.Listen("tcp", a.restAddr)
+	if err != nil {
+		lis.Close()
+		return fmt.Errorf("failed to listen on %s: %w", a.restAddr, err)
 	}
-}
 
-func (a *App) Run() {
-	// run execution api
-	go func() {
-		server := a.makeExecutionServer()
-		lis, err := net.Listen("tcp", a.executionRPC)
-		if err != nil {
-			log.Fatalf("failed to listen: %v", err)
-		}
-		grpcServer := grpc.NewServer()
-		astriaGrpc.RegisterExecutionServiceServer(grpcServer, server)
-		if err := grpcServer.Serve(lis); err != nil {
-			log.Fatalf("failed to serve: %v", err)
-		}
-	}()
+	grpcOpts := []grpc.ServerOption{
+		grpc.StatsHandler(otelgrpc.NewServerHandler()),
+		grpc.ChainUnaryInterceptor(unaryRequestIDInterceptor, a.unaryAuthInterceptor),
+		grpc.ChainStreamInterceptor(streamRequestIDInterceptor, a.streamAuthInterceptor),
+	}
+	if a.serverTLS != nil {
+		grpcOpts = append(grpcOpts, grpc.Creds(credentials.NewTLS(a.serverTLS.grpcConfig())))
+	}
+	grpcServer := grpc.NewServer(grpcOpts...)
+	astriaGrpc.RegisterExecutionServiceServer(grpcServer, a.executionServer)
+	messengerv1.RegisterMessengerQueryServiceServer(grpcServer, a.makeQueryServer())
+	if a.adminToken != "" {
+		messengerv1.RegisterAdminServiceServer(grpcServer, NewAdminServiceServer(a))
+	}
+	// the standard health service reports the services as serving until
+	// shutdown starts
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, healthServer)
+	a.startedAt = time.Now()
 
-	// run rest api server
 	a.setupRestRoutes()
 	server := a.makeRestServer()
 
-	log.Infof("API server listening on %s\n", a.restAddr)
-	go func() {
-		err := server.ListenAndServe()
-		if errors.Is(err, http.ErrServerClosed) {
-			log.Warnf("rest api server closed\n")
-		} else if err != nil {
-			log.Errorf("error listening for rest api server: %s\n", err)
+	// components are stopped in reverse order: webhooks and event clients
+	// first, so that long-lived streams don't hold up draining the servers,
+	// then the REST and gRPC servers, then the event hub, which takes the
+	// blocks executed until the servers are stopped, then the composer
+	// connection, and the tracing exporter last to flush the spans of the
+	// shutdown
+	lifecycle := NewLifecycle(a.shutdownTimeout)
+	lifecycle.Add("tracing", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}, func(ctx context.Context) error {
+		// an unreachable collector only loses spans, it doesn't fail the
+		// shutdown
+		if err := a.shutdownTracing(ctx); err != nil {
+			log.Warnf("spans not exported on shutdown: %s\n", err)
 		}
-	}()
-
-	// send new messages to all connected ws clients
-	go func() {
-		for block := range a.newBlockChan {
-			// only write blocks with transactions
-			if len(block.Txs) == 0 {
-				continue
-			}
-
-			// decode transactions into format that the client can handle
-			txsJson := prepareBlockForClient(block.Txs)
-
-			if len(block.Txs) == 0 {
-				log.Info("post txs filtering no txs remaining")
-				continue
-			}
-			for client := range a.wsClients {
-				select {
-				case client.egress <- txsJson:
-				default:
-					log.Warnf("Could not send transactions to ws client: %s", txsJson)
-				}
-			}
+		return nil
+	})
+	if len(a.tlsReloaders) > 0 {
+		lifecycle.Add("tls reload", func(ctx context.Context) error {
+			watchTLSFiles(ctx, a.tlsReloadInterval, a.tlsReloaders)
+			return nil
+		}, func(context.Context) error {
+			return nil
+		})
+	}
+	lifecycle.Add("composer connection", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}, func(context.Context) error {
+		return a.sequencerClient.Close()
+	})
+	// send new blocks and commitment updates to all connected event clients
+	lifecycle.Add("event hub", func(context.Context) error {
+		a.eventHub.Run()
+		return nil
+	}, func(context.Context) error {
+		a.eventHub.Stop()
+		return nil
+	})
+	lifecycle.Add("execution api", func(context.Context) error {
+		log.Infof("Execution API listening on %s\n", lis.Addr())
+		for service := range grpcServer.GetServiceInfo() {
+			healthServer.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
 		}
-	}()
-
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
-	<-c
+		a.executionServing.Store(true)
+		return grpcServer.Serve(lis)
+	}, func(ctx context.Context) error {
+		a.executionServing.Store(false)
+		healthServer.Shutdown()
+		stopped := make(chan struct{})
+		go func() {
+			grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+			return nil
+		case <-ctx.Done():
+			grpcServer.Stop()
+			return fmt.Errorf("gRPC calls still in flight: %w", ctx.Err())
+		}
+	})
+	lifecycle.Add("rest api", func(context.Context) error {
+		var err error
+		if server.TLSConfig != nil {
+			log.Infof("API server listening on %s with TLS\n", restLis.Addr())
+			// the certificate comes from the TLS config
+			err = server.ServeTLS(restLis, "", "")
+		} else {
+			log.Infof("API server listening on %s\n", restLis.Addr())
+			err = server.Serve(restLis)
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			return err
+		}
+		return nil
+	}, func(ctx context.Context) error {
+		if err := server.Shutdown(ctx); err != nil {
+			server.Close()
+			return err
+		}
+		return nil
+	})
+	lifecycle.Add("event clients", func(ctx context.Context) error {
+		<-ctx.Done()
+		return nil
+	}, func(context.Context) error {
+		a.eventHub.CloseClients()
+		return nil
+	})
+	lifecycle.Add("webhooks", func(ctx context.Context) error {
+		a.webhooks.Start()
+		<-ctx.Done()
+		return nil
+	}, a.webhooks.Stop)
 
-	log.Info("Shutting down server...")
-	if err := server.Shutdown(context.Background()); err != nil {
-		log.Fatalf("Could not gracefully shutdown the server: %v\n", err)
-	}
-	log.Info("Server gracefully stopped")
+	return lifecycle.Run(ctx)
 }
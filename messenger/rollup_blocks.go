@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	astriaPb "buf.build/gen/go/astria/execution-apis/protocolbuffers/go/astria/execution/v1alpha2"
@@ -49,78 +51,203 @@ func (b *Block) ToPb() (*astriaPb.Block, error) {
 	}, nil
 }
 
-// GenesisBlock creates the genesis block.
-func GenesisBlock() Block {
-	genesisTx := GenesisTransaction()
+// Commitment is the soft and firm height of the chain.
+type Commitment struct {
+	Soft uint32 `json:"soft"`
+	Firm uint32 `json:"firm"`
+}
 
-	genesisHash, err := HashTxs([][]byte{genesisTx})
-	if err != nil {
-		log.Errorf("error hashing genesis tx: %s\n", err)
-		panic(err)
-	}
+// Messenger is a struct that manages the blocks in the blockchain.
+//
+// New blocks and commitment updates are sent on NewBlockChan and
+// CommitmentChan. The sends block until received, so the channels must be
+// drained, but blocks are never dropped.
+type RollupBlocks struct {
+	Blocks         []Block
+	soft           uint32
+	firm           uint32
+	NewBlockChan   chan Block
+	CommitmentChan chan Commitment
+	// state is replaced by a rollback, read it with State
+	state *MessengerState
+	mu    sync.RWMutex
+}
 
-	return Block{
-		ParentHash: [32]byte{0x00000000},
-		Hash:       genesisHash,
-		Height:     0,
-		Timestamp:  time.Now(),
-		Txs: [][]byte{
-			genesisTx,
-		},
+// NewRollupBlocks creates the chain of blocks starting at a genesis block.
+func NewRollupBlocks(genesis Block, newBlockChan chan Block, commitmentChan chan Commitment) *RollupBlocks {
+	rb := &RollupBlocks{
+		Blocks:         []Block{genesis},
+		soft:           0,
+		firm:           0,
+		NewBlockChan:   newBlockChan,
+		CommitmentChan: commitmentChan,
 	}
+	rb.RebuildState()
+	return rb
 }
 
-// Messenger is a struct that manages the blocks in the blockchain.
-type RollupBlocks struct {
-	Blocks       []Block
-	soft         uint32
-	firm         uint32
-	NewBlockChan chan Block
-}
-
-func NewRollupBlocks(newBlockChan chan Block) *RollupBlocks {
-	return &RollupBlocks{
-		Blocks:       []Block{GenesisBlock()},
-		soft:         0,
-		firm:         0,
-		NewBlockChan: newBlockChan,
+// RebuildState rebuilds the messenger state, including the history and
+// search indexes, from the stored blocks.
+func (rb *RollupBlocks) RebuildState() {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+	rb.rebuildState()
+}
+
+// rebuildState rebuilds the messenger state. The caller must hold mu.
+func (rb *RollupBlocks) rebuildState() {
+	state := NewMessengerState()
+	for i := range rb.Blocks {
+		state.ApplyBlock(&rb.Blocks[i])
 	}
+	rb.state = state
+	log.Debugf("rebuilt messenger state from %d blocks\n", len(rb.Blocks))
+}
+
+// State returns the messenger state of the stored blocks. A rollback
+// replaces it, so it is read again for every request rather than kept.
+func (rb *RollupBlocks) State() *MessengerState {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return rb.state
 }
 
 // GetSingleBlock retrieves a block by its height, failing if the requested
 // height is higher than the current height.
 func (rb *RollupBlocks) GetSingleBlock(height uint32) (*Block, error) {
 	log.Debugf("getting block at height %d\n", height)
-	if height > uint32(len(rb.Blocks)) {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	if height >= uint32(len(rb.Blocks)) {
 		return nil, errors.New("block not found")
 	}
 	return &rb.Blocks[height], nil
 }
 
 func (rb *RollupBlocks) GetSoftBlock() *Block {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
 	return &rb.Blocks[rb.soft]
 }
 
 func (rb *RollupBlocks) GetFirmBlock() *Block {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
 	return &rb.Blocks[rb.firm]
 }
 
 func (rb *RollupBlocks) GetLatestBlock() *Block {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return rb.latestBlock()
+}
+
+func (rb *RollupBlocks) latestBlock() *Block {
 	return &rb.Blocks[len(rb.Blocks)-1]
 }
 
 func (rb *RollupBlocks) Height() uint32 {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
 	return uint32(len(rb.Blocks))
 }
 
+// AddBlock appends a block, applies it to the state and sends it on
+// NewBlockChan. Blocks are expected to be added one at a time.
 func (rb *RollupBlocks) AddBlock(block Block) error {
-	if rb.GetLatestBlock().Height > 0 && !bytes.Equal(block.ParentHash[:], rb.GetLatestBlock().Hash[:]) {
+	rb.mu.Lock()
+	if rb.latestBlock().Height > 0 && !bytes.Equal(block.ParentHash[:], rb.latestBlock().Hash[:]) {
+		rb.mu.Unlock()
 		return errors.New("invalid prev block hash")
 	}
 	rb.Blocks = append(rb.Blocks, block)
-	select {
-	case rb.NewBlockChan <- block:
+	rb.state.ApplyBlock(&block)
+	rb.mu.Unlock()
+
+	// send outside the lock, the receiver reads the blocks
+	rb.NewBlockChan <- block
+	return nil
+}
+
+// SetCommitment updates the soft and firm heights.
+func (rb *RollupBlocks) SetCommitment(soft uint32, firm uint32) {
+	rb.mu.Lock()
+	rb.soft = soft
+	rb.firm = firm
+	rb.mu.Unlock()
+
+	rb.CommitmentChan <- Commitment{Soft: soft, Firm: firm}
+}
+
+// CommittedHeight returns the height of the latest block at the given
+// commitment level.
+func (rb *RollupBlocks) CommittedHeight(level CommitmentLevel) uint32 {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	switch level {
+	case CommitmentSoft:
+		return rb.soft
+	case CommitmentFirm:
+		return rb.firm
 	default:
+		return rb.latestBlock().Height
 	}
-	return nil
+}
+
+// CommitmentBlocks returns the latest executed, soft and firm blocks at once.
+func (rb *RollupBlocks) CommitmentBlocks() (executed Block, soft Block, firm Block) {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	return *rb.latestBlock(), rb.Blocks[rb.soft], rb.Blocks[rb.firm]
+}
+
+// StorageStats describes the blocks and state held in memory.
+type StorageStats struct {
+	Blocks int `json:"blocks"`
+	// TxBytes is the size of the transactions of all blocks
+	TxBytes int `json:"txBytes"`
+	StateStats
+}
+
+// Stats returns the storage stats.
+func (rb *RollupBlocks) Stats() StorageStats {
+	rb.mu.RLock()
+	defer rb.mu.RUnlock()
+	stats := StorageStats{
+		Blocks:     len(rb.Blocks),
+		StateStats: rb.state.Stats(),
+	}
+	for i := range rb.Blocks {
+		for _, tx := range rb.Blocks[i].Txs {
+			stats.TxBytes += len(tx)
+		}
+	}
+	return stats
+}
+
+// Rollback removes the blocks above height, which may only be executed or
+// soft committed blocks, and rebuilds the state from the remaining blocks.
+// The soft height is lowered to height if it was above it. It returns the
+// number of blocks removed.
+func (rb *RollupBlocks) Rollback(height uint32) (int, error) {
+	rb.mu.Lock()
+	latest := rb.latestBlock().Height
+	if height < rb.firm {
+		rb.mu.Unlock()
+		return 0, fmt.Errorf("cannot roll back below the firm height %d", rb.firm)
+	}
+	if height >= latest {
+		rb.mu.Unlock()
+		return 0, fmt.Errorf("height %d is not below the latest height %d", height, latest)
+	}
+	// copy the kept blocks, so that blocks executed after the rollback don't
+	// overwrite removed blocks still referenced by readers
+	rb.Blocks = append([]Block(nil), rb.Blocks[:height+1]...)
+	rb.soft = min(rb.soft, height)
+	commitment := Commitment{Soft: rb.soft, Firm: rb.firm}
+	rb.rebuildState()
+	rb.mu.Unlock()
+
+	rb.CommitmentChan <- commitment
+	return int(latest - height), nil
 }
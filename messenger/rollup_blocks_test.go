@@ -0,0 +1,81 @@
+package messenger
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRollbackReplacesStateWhileRead(t *testing.T) {
+	genesis, err := DefaultGenesis("test").Block()
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks := make(chan Block)
+	commitments := make(chan Commitment)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case <-blocks:
+			case <-commitments:
+			case <-done:
+				return
+			}
+		}
+	}()
+	rb := NewRollupBlocks(genesis, blocks, commitments)
+
+	addBlocks := func(count int) {
+		for i := 0; i < count; i++ {
+			tx, err := encodeTx(Transaction{Sender: "alice", Message: fmt.Sprintf("message %d", i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			latest := rb.GetLatestBlock()
+			if err := rb.AddBlock(NewBlock(latest.Hash[:], rb.Height(), [][]byte{tx}, time.Now())); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// readers take the state while rollbacks replace it, which the race
+	// detector reports unless the state is read under the lock
+	stop := make(chan struct{})
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				if _, err := rb.State().QueryMessages(MessageQuery{Limit: 10}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		addBlocks(3)
+		if _, err := rb.Rollback(0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	close(stop)
+	readers.Wait()
+
+	page, err := rb.State().QueryMessages(MessageQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Messages) != 1 {
+		t.Errorf("%d messages after rolling back to genesis, want 1", len(page.Messages))
+	}
+}
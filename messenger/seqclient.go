@@ -3,10 +3,16 @@ package messenger
 import (
 	"buf.build/gen/go/astria/composer-apis/grpc/go/astria/composer/v1alpha1/composerv1alpha1grpc"
 	"context"
-	"crypto/ed25519"
 	"fmt"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
+	"go.opentelemetry.io/otel/attribute"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
 
 	astriaPb "buf.build/gen/go/astria/astria/protocolbuffers/go/astria/sequencer/v1"
 	astriaComposerPb "buf.build/gen/go/astria/composer-apis/protocolbuffers/go/astria/composer/v1alpha1"
@@ -20,32 +26,126 @@ import (
 type SequencerClient struct {
 	c              *client.Client
 	composerClient *grpc.ClientConn
-	signer         *client.Signer
+	keys           KeyProvider
 	nonce          uint32
 	rollupId       []byte
+	// submissions tracks the composer submissions, it is a pointer as the
+	// client is copied into the app
+	submissions *submissionTracker
+}
+
+// SubmissionStats describes the submissions to the composer. Submissions
+// are made while the REST request waits, so the in-flight submissions are
+// the outbound queue.
+type SubmissionStats struct {
+	// Composer is the state of the composer connection
+	Composer      string     `json:"composer"`
+	InFlight      int        `json:"inFlight"`
+	Succeeded     uint64     `json:"succeeded"`
+	Failed        uint64     `json:"failed"`
+	LastSuccessAt *time.Time `json:"lastSuccessAt,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	LastErrorAt   *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+// submissionTracker counts submissions in flight and their results.
+type submissionTracker struct {
+	mu    sync.Mutex
+	stats SubmissionStats
+}
+
+func (t *submissionTracker) start() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.InFlight++
 }
 
-// NewSequencerClient creates a new SequencerClient.
-func NewSequencerClient(sequencerAddr string, composerAddr string, rollupId []byte, private ed25519.PrivateKey) *SequencerClient {
-	signer := client.NewSigner(private)
+func (t *submissionTracker) done(err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stats.InFlight--
+	now := time.Now().UTC()
+	if err != nil {
+		t.stats.Failed++
+		t.stats.LastError = err.Error()
+		t.stats.LastErrorAt = &now
+		return
+	}
+	t.stats.Succeeded++
+	t.stats.LastSuccessAt = &now
+}
 
+// NewSequencerClient creates a new SequencerClient, dialing the composer
+// with the given transport credentials and signing with the given key.
+func NewSequencerClient(sequencerAddr string, composerAddr string, composerCreds credentials.TransportCredentials, rollupId []byte, keys KeyProvider) (*SequencerClient, error) {
 	// default tendermint RPC endpoint
 	c, err := client.NewClient(sequencerAddr)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("sequencer client: %w", err)
 	}
 
-	conn, err := grpc.Dial(composerAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	conn, err := grpc.Dial(composerAddr,
+		grpc.WithTransportCredentials(composerCreds),
+		grpc.WithStatsHandler(otelgrpc.NewClientHandler()),
+	)
 	if err != nil {
-		panic(err)
+		return nil, fmt.Errorf("composer client: %w", err)
 	}
 
 	return &SequencerClient{
 		c:              c,
 		composerClient: conn,
-		signer:         signer,
+		keys:           keys,
 		rollupId:       rollupId,
+		submissions:    &submissionTracker{},
+	}, nil
+}
+
+// Close closes the composer connection.
+func (sc *SequencerClient) Close() error {
+	return sc.composerClient.Close()
+}
+
+// ComposerState returns the state of the composer connection, starting to
+// connect if it is idle.
+func (sc *SequencerClient) ComposerState() connectivity.State {
+	state := sc.composerClient.GetState()
+	if state == connectivity.Idle {
+		sc.composerClient.Connect()
 	}
+	return state
+}
+
+// SubmissionStats returns the stats of the composer submissions.
+func (sc *SequencerClient) SubmissionStats() SubmissionStats {
+	sc.submissions.mu.Lock()
+	stats := sc.submissions.stats
+	sc.submissions.mu.Unlock()
+	stats.Composer = sc.composerClient.GetState().String()
+	return stats
+}
+
+// signTransaction signs a transaction with the sequencer key, paying the
+// fees in the default asset unless another one is set.
+func (sc *SequencerClient) signTransaction(ctx context.Context, tx *astriaPb.UnsignedTransaction) (*astriaPb.SignedTransaction, error) {
+	for _, action := range tx.Actions {
+		if seq, ok := action.Value.(*astriaPb.Action_SequenceAction); ok && len(seq.SequenceAction.FeeAssetId) == 0 {
+			seq.SequenceAction.FeeAssetId = client.DefaultAstriaAssetID[:]
+		}
+	}
+	bytes, err := proto.Marshal(tx)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := sc.keys.Sign(ctx, bytes)
+	if err != nil {
+		return nil, err
+	}
+	return &astriaPb.SignedTransaction{
+		Transaction: tx,
+		Signature:   signature,
+		PublicKey:   sc.keys.PublicKey(),
+	}, nil
 }
 
 // broadcastTxSync broadcasts a transaction synchronously.
@@ -54,19 +154,33 @@ func (sc *SequencerClient) broadcastTxSync(tx *astriaPb.SignedTransaction) (*ten
 	return sc.c.BroadcastTxSync(context.Background(), tx)
 }
 
-func (sc *SequencerClient) SendMessageViaComposer(tx []byte) error {
+// SendMessageViaComposer submits a transaction to the composer, which
+// bundles it into a sequencer transaction.
+func (sc *SequencerClient) SendMessageViaComposer(ctx context.Context, tx []byte) (err error) {
+	ctx, span := tracer.Start(ctx, "SequencerClient.SendMessageViaComposer")
+	span.SetAttributes(attrTxHash.String(txHash(tx)), attribute.Int("messenger.tx_bytes", len(tx)))
+	defer func() { endSpan(span, err) }()
+
 	log.Debug("broadcasting tx through composer!")
+	start := time.Now()
+	sc.submissions.start()
+	defer func() {
+		sc.submissions.done(err)
+		composerSubmissionDuration.Observe(time.Since(start).Seconds())
+	}()
 
 	grpcCollectorServiceClient := composerv1alpha1grpc.NewGrpcCollectorServiceClient(sc.composerClient)
 	// if the request succeeds, then an empty response will be returned which can be ignored for now
-	_, err := grpcCollectorServiceClient.SubmitRollupTransaction(context.Background(), &astriaComposerPb.SubmitRollupTransactionRequest{
+	_, err = grpcCollectorServiceClient.SubmitRollupTransaction(ctx, &astriaComposerPb.SubmitRollupTransactionRequest{
 		RollupId: sc.rollupId,
 		Data:     tx,
 	})
 	if err != nil {
+		composerSubmissions.WithLabelValues("failure").Inc()
 		return err
 	}
 
+	composerSubmissions.WithLabelValues("success").Inc()
 	return nil
 }
 
@@ -88,12 +202,12 @@ func (sc *SequencerClient) SendMessage(tx []byte) (*tendermintPb.ResultBroadcast
 		},
 	}
 
-	signed, err := sc.signer.SignTransaction(unsigned)
+	signed, err := sc.signTransaction(context.Background(), unsigned)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 
-	log.Debugf("submitting tx to sequencer: %s.", tx)
+	log.WithField(fieldTxHash, txHash(tx)).Debug("submitting tx to sequencer")
 
 	resp, err := sc.broadcastTxSync(signed)
 	if err != nil {
@@ -101,7 +215,7 @@ func (sc *SequencerClient) SendMessage(tx []byte) (*tendermintPb.ResultBroadcast
 	}
 	if resp.Code == 4 {
 		// fetch new nonce
-		newNonce, err := sc.c.GetNonce(context.Background(), sc.signer.Address())
+		newNonce, err := sc.c.GetNonce(context.Background(), SequencerAddress(sc.keys.PublicKey()))
 		if err != nil {
 			return nil, err
 		}
@@ -112,7 +226,7 @@ func (sc *SequencerClient) SendMessage(tx []byte) (*tendermintPb.ResultBroadcast
 			Nonce:   sc.nonce,
 			Actions: unsigned.Actions,
 		}
-		signed, err = sc.signer.SignTransaction(unsigned)
+		signed, err = sc.signTransaction(context.Background(), unsigned)
 		if err != nil {
 			return nil, err
 		}
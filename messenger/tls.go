@@ -0,0 +1,276 @@
+package messenger
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// TLS is optional on every link. With TLS_CERT_FILE and TLS_KEY_FILE the
+// REST API, including WebSockets, is served over HTTPS and the gRPC server
+// over TLS, and TLS_GRPC_CLIENT_CA_FILE additionally requires gRPC clients,
+// such as the conductor, to present a certificate it signed. The composer is
+// dialed over TLS if COMPOSER_TLS is set, with a client certificate for mTLS
+// if one is configured. Certificates, keys and client CAs are reloaded when
+// their files change, so that they can be rotated without a restart.
+
+// reloadable is a value loaded from files, which is loaded again when any of
+// them is modified.
+type reloadable[T any] struct {
+	name  string
+	files []string
+	load  func() (T, error)
+
+	mu      sync.RWMutex
+	value   T
+	modTime time.Time
+}
+
+// newReloadable loads a value, returning an error if it can't be loaded.
+func newReloadable[T any](name string, files []string, load func() (T, error)) (*reloadable[T], error) {
+	r := &reloadable[T]{name: name, files: files, load: load}
+	if _, err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *reloadable[T]) String() string {
+	return r.name
+}
+
+// get returns the last value loaded.
+func (r *reloadable[T]) get() T {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.value
+}
+
+// lastModified returns the latest modification time of the files.
+func (r *reloadable[T]) lastModified() (time.Time, error) {
+	var latest time.Time
+	for _, file := range r.files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// reload loads the value again if the files were modified since it was last
+// loaded, returning whether it did. The last value is kept on errors.
+func (r *reloadable[T]) reload() (bool, error) {
+	modTime, err := r.lastModified()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", r.name, err)
+	}
+	r.mu.RLock()
+	unchanged := modTime.Equal(r.modTime)
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+	value, err := r.load()
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", r.name, err)
+	}
+	r.mu.Lock()
+	r.value = value
+	r.modTime = modTime
+	r.mu.Unlock()
+	return true, nil
+}
+
+// reloader is a reloadable of any type.
+type reloader interface {
+	reload() (bool, error)
+	String() string
+}
+
+// loadKeyPair returns a reloadable certificate and key.
+func loadKeyPair(name string, certFile string, keyFile string) (*reloadable[*tls.Certificate], error) {
+	return newReloadable(name, []string{certFile, keyFile}, func() (*tls.Certificate, error) {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		return &cert, nil
+	})
+}
+
+// loadCertPool returns a reloadable pool of the PEM certificates of a file.
+func loadCertPool(name string, file string) (*reloadable[*x509.CertPool], error) {
+	return newReloadable(name, []string{file}, func() (*x509.CertPool, error) {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no PEM certificates found")
+		}
+		return pool, nil
+	})
+}
+
+// ServerTLSConfig configures TLS on the REST and gRPC servers.
+type ServerTLSConfig struct {
+	CertFile string
+	KeyFile  string
+	// GRPCClientCAFile requires gRPC clients to present a certificate
+	// signed by one of its CAs if set
+	GRPCClientCAFile string
+}
+
+// ComposerTLSConfig configures TLS on the composer connection.
+type ComposerTLSConfig struct {
+	Enabled bool
+	// CAFile verifies the composer certificate, the system roots are used
+	// if it is empty
+	CAFile string
+	// CertFile and KeyFile are the client certificate of mTLS, if set
+	CertFile   string
+	KeyFile    string
+	ServerName string
+}
+
+// serverTLS holds the reloadable TLS configs of the servers.
+type serverTLS struct {
+	cert      *reloadable[*tls.Certificate]
+	clientCAs *reloadable[*x509.CertPool]
+}
+
+// newServerTLS loads the server certificate and client CAs, returning nil if
+// TLS is disabled.
+func newServerTLS(cfg ServerTLSConfig) (*serverTLS, error) {
+	if cfg.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := loadKeyPair("server certificate", cfg.CertFile, cfg.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	s := &serverTLS{cert: cert}
+	if cfg.GRPCClientCAFile != "" {
+		if s.clientCAs, err = loadCertPool("gRPC client CAs", cfg.GRPCClientCAFile); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *serverTLS) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return s.cert.get(), nil
+}
+
+// restConfig returns the TLS config of the REST server.
+func (s *serverTLS) restConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.getCertificate,
+	}
+}
+
+// grpcConfig returns the TLS config of the gRPC server, which verifies
+// client certificates against the client CAs loaded at each handshake. The
+// config of a handshake replaces the one the gRPC credentials set up, so it
+// must offer HTTP/2 itself.
+func (s *serverTLS) grpcConfig() *tls.Config {
+	cfg := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: s.getCertificate,
+	}
+	if s.clientCAs != nil {
+		cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{
+				MinVersion:     tls.VersionTLS12,
+				GetCertificate: s.getCertificate,
+				ClientAuth:     tls.RequireAndVerifyClientCert,
+				ClientCAs:      s.clientCAs.get(),
+				NextProtos:     []string{"h2"},
+			}, nil
+		}
+	}
+	return cfg
+}
+
+// reloaders returns the reloadable values of the servers.
+func (s *serverTLS) reloaders() []reloader {
+	reloaders := []reloader{s.cert}
+	if s.clientCAs != nil {
+		reloaders = append(reloaders, s.clientCAs)
+	}
+	return reloaders
+}
+
+// composerCredentials returns the transport credentials of the composer
+// connection and the reloadable client certificate, if any.
+func composerCredentials(cfg ComposerTLSConfig) (credentials.TransportCredentials, []reloader, error) {
+	if !cfg.Enabled {
+		return insecure.NewCredentials(), nil, nil
+	}
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: cfg.ServerName,
+	}
+	var reloaders []reloader
+	if cfg.CAFile != "" {
+		// unlike certificates, the CAs verifying the composer are only read
+		// at startup
+		data, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(data) {
+			return nil, nil, fmt.Errorf("%s: no PEM certificates found", cfg.CAFile)
+		}
+	}
+	if cfg.CertFile != "" {
+		cert, err := loadKeyPair("composer client certificate", cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		tlsConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return cert.get(), nil
+		}
+		reloaders = append(reloaders, cert)
+	}
+	return credentials.NewTLS(tlsConfig), reloaders, nil
+}
+
+// watchTLSFiles reloads the certificates, keys and CAs whose files changed
+// every interval until ctx is done. Files which fail to load are logged and
+// the previous values kept.
+func watchTLSFiles(ctx context.Context, interval time.Duration, reloaders []reloader) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, r := range reloaders {
+			reloaded, err := r.reload()
+			if err != nil {
+				log.Warnf("error reloading TLS files, keeping the previous ones: %s\n", err)
+			} else if reloaded {
+				log.WithField("name", r.String()).Info("TLS files reloaded")
+			}
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package messenger
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	stdlog "log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCA issues certificates for the TLS tests.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	// file is the PEM file of the CA certificate
+	file string
+}
+
+var testSerial int64
+
+func newTestKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func writePEM(t *testing.T, path string, blockType string, der []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTestCA creates a CA whose certificate is written to name.pem in dir.
+func newTestCA(t *testing.T, dir string, name string) *testCA {
+	t.Helper()
+	testSerial++
+	key := newTestKey(t)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(testSerial),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := &testCA{cert: cert, key: key, file: filepath.Join(dir, name+".pem")}
+	writePEM(t, ca.file, "CERTIFICATE", der)
+	return ca
+}
+
+// pool returns a pool of the CA certificate.
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue writes a certificate for 127.0.0.1 and its key to certFile and
+// keyFile, returning its serial number.
+func (ca *testCA) issue(t *testing.T, certFile string, keyFile string, usage x509.ExtKeyUsage) *big.Int {
+	t.Helper()
+	testSerial++
+	key := newTestKey(t)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(testSerial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "PRIVATE KEY", keyDER)
+	return template.SerialNumber
+}
+
+// clientCert issues a client certificate.
+func (ca *testCA) clientCert(t *testing.T, dir string, name string) tls.Certificate {
+	t.Helper()
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	ca.issue(t, certFile, keyFile, x509.ExtKeyUsageClientAuth)
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+// touch moves the modification time of files forward, as file systems with
+// a coarse resolution may not tell a rotated file from the previous one.
+func touch(t *testing.T, files ...string) {
+	t.Helper()
+	future := time.Now().Add(time.Minute)
+	for _, file := range files {
+		if err := os.Chtimes(file, future, future); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// serveGRPC serves the health service over the gRPC TLS config until the
+// test ends, returning the address.
+func serveGRPC(t *testing.T, s *serverTLS) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(s.grpcConfig())))
+	healthpb.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+	return lis.Addr().String()
+}
+
+// checkGRPC calls the health service with the client config.
+func checkGRPC(addr string, config *tls.Config) error {
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+	return err
+}
+
+func TestServerTLSServesREST(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca.issue(t, certFile, keyFile, x509.ExtKeyUsageServerAuth)
+	s, err := newServerTLS(ServerTLSConfig{CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{
+		Handler:   http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		TLSConfig: s.restConfig(),
+		// the rejected handshake is expected
+		ErrorLog: stdlog.New(io.Discard, "", 0),
+	}
+	go server.ServeTLS(lis, "", "")
+	defer server.Close()
+
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: ca.pool()}}}
+	resp, err := client.Get("https://" + lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status %s", resp.Status)
+	}
+
+	// the certificate isn't trusted without the CA
+	if resp, err := http.Get("https://" + lis.Addr().String()); err == nil {
+		resp.Body.Close()
+		t.Error("request without the CA succeeded")
+	}
+}
+
+func TestServerTLSRequiresGRPCClientCertificates(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	clientCA := newTestCA(t, dir, "client-ca")
+	otherCA := newTestCA(t, dir, "other-ca")
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca.issue(t, certFile, keyFile, x509.ExtKeyUsageServerAuth)
+	s, err := newServerTLS(ServerTLSConfig{CertFile: certFile, KeyFile: keyFile, GRPCClientCAFile: clientCA.file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveGRPC(t, s)
+
+	if err := checkGRPC(addr, &tls.Config{
+		RootCAs:      ca.pool(),
+		Certificates: []tls.Certificate{clientCA.clientCert(t, dir, "client")},
+	}); err != nil {
+		t.Fatalf("client with a certificate of the client CA: %s", err)
+	}
+	if err := checkGRPC(addr, &tls.Config{RootCAs: ca.pool()}); err == nil {
+		t.Error("client without a certificate accepted")
+	}
+	if err := checkGRPC(addr, &tls.Config{
+		RootCAs:      ca.pool(),
+		Certificates: []tls.Certificate{otherCA.clientCert(t, dir, "other")},
+	}); err == nil {
+		t.Error("client with a certificate of another CA accepted")
+	}
+}
+
+func TestServerTLSReloadsRotatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCA(t, dir, "ca")
+	clientCA := newTestCA(t, dir, "client-ca")
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	ca.issue(t, certFile, keyFile, x509.ExtKeyUsageServerAuth)
+	clientCAFile := clientCA.file
+	s, err := newServerTLS(ServerTLSConfig{CertFile: certFile, KeyFile: keyFile, GRPCClientCAFile: clientCAFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := serveGRPC(t, s)
+
+	// the server certificate and client CAs are rotated
+	serial := ca.issue(t, certFile, keyFile, x509.ExtKeyUsageServerAuth)
+	rotatedCA := newTestCA(t, dir, "rotated-ca")
+	data, err := os.ReadFile(rotatedCA.file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(clientCAFile, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, certFile, keyFile, clientCAFile)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go watchTLSFiles(ctx, 10*time.Millisecond, s.reloaders())
+
+	var served *big.Int
+	config := &tls.Config{
+		RootCAs:      ca.pool(),
+		Certificates: []tls.Certificate{rotatedCA.clientCert(t, dir, "client")},
+		VerifyConnection: func(state tls.ConnectionState) error {
+			served = state.PeerCertificates[0].SerialNumber
+			return nil
+		},
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		err := checkGRPC(addr, config)
+		if err == nil && served.Cmp(serial) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated files not used: serial %v, want %v, error %v", served, serial, err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// a rotation to a broken file keeps the last certificate
+	if err := os.WriteFile(certFile, []byte("broken"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	touch(t, certFile)
+	if _, err := s.cert.reload(); err == nil {
+		t.Fatal("reloading a broken certificate succeeded")
+	}
+	if err := checkGRPC(addr, config); err != nil || served.Cmp(serial) != 0 {
+		t.Errorf("after a broken rotation: serial %v, want %v, error %v", served, serial, err)
+	}
+}
@@ -0,0 +1,99 @@
+package messenger
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer creates the spans of the package. It uses the global tracer
+// provider, which is a no-op unless an exporter is configured.
+var tracer = otel.Tracer("github.com/astriaorg/messenger-rollup/messenger")
+
+// Attributes shared by the spans of a submission and of the block which
+// includes it, so that the two can be found together.
+const (
+	attrTxHash    = attribute.Key("messenger.tx_hash")
+	attrTxHashes  = attribute.Key("messenger.tx_hashes")
+	attrHeight    = attribute.Key("messenger.block_height")
+	attrBlockHash = attribute.Key("messenger.block_hash")
+)
+
+// TracingConfig configures the export of traces.
+type TracingConfig struct {
+	// Exporter is none or otlp
+	Exporter string
+	// Endpoint is the host:port of the OTLP gRPC collector, if empty the
+	// standard OTEL_EXPORTER_OTLP_* variables or localhost:4317 are used
+	Endpoint string
+	Insecure bool
+	// SampleRatio is the fraction of traces started by the node which are
+	// sampled, traces started by callers follow their sampling decision
+	SampleRatio float64
+	// ServiceName is reported as the service.name resource attribute
+	ServiceName string
+}
+
+// setupTracing installs the global tracer provider and propagator for the
+// configured exporter. The returned function flushes and stops the exporter.
+func setupTracing(cfg TracingConfig) (func(ctx context.Context) error, error) {
+	if cfg.Exporter == "none" {
+		return func(context.Context) error { return nil }, nil
+	}
+	if cfg.Exporter != "otlp" {
+		return nil, fmt.Errorf("unknown tracing exporter %q", cfg.Exporter)
+	}
+
+	opts := []otlptracegrpc.Option{}
+	if cfg.Endpoint != "" {
+		opts = append(opts, otlptracegrpc.WithEndpoint(cfg.Endpoint))
+	}
+	if cfg.Insecure {
+		opts = append(opts, otlptracegrpc.WithInsecure())
+	}
+	// the client connects lazily, an unreachable collector only drops spans
+	exporter, err := otlptracegrpc.New(context.Background(), opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(cfg.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// txHash returns the hex sha256 of an encoded transaction, which identifies
+// it in traces from submission to execution.
+func txHash(tx []byte) string {
+	hash := sha256.Sum256(tx)
+	return hex.EncodeToString(hash[:])
+}
+
+// endSpan records err on span, if any, and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,151 @@
+package messenger
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/protobuf/proto"
+
+	messengerv1 "github.com/astriaorg/messenger-rollup/gen/messenger/v1"
+)
+
+// Encoded transactions are a versioned envelope:
+//
+//	byte 0:  envelope version
+//	byte 1:  type tag (messengerv1.TxType)
+//	byte 2+: protobuf encoded payload for the type
+//
+// Transactions stored before the envelope was introduced are plain JSON
+// objects and always start with '{', which is never a valid version byte.
+const (
+	txVersionV1 byte = 0x01
+
+	legacyJSONPrefix byte = '{'
+)
+
+// encode transaction into bytes to be sent to the sequencer
+func encodeTx(tx Transaction) ([]byte, error) {
+	var (
+		tag     messengerv1.TxType
+		payload proto.Message
+	)
+	switch tx.Kind() {
+	case TxTypeMessage:
+		tag = messengerv1.TxType_TX_TYPE_MESSAGE
+		payload = &messengerv1.Message{
+			Sender:   tx.Sender,
+			Message:  tx.Message,
+			PowNonce: tx.PowNonce,
+			Channel:  tx.Channel,
+		}
+	case TxTypeReply:
+		tag = messengerv1.TxType_TX_TYPE_REPLY
+		payload = &messengerv1.Reply{
+			Sender:   tx.Sender,
+			Message:  tx.Message,
+			ParentId: tx.ParentID,
+			PowNonce: tx.PowNonce,
+		}
+	case TxTypeReaction:
+		tag = messengerv1.TxType_TX_TYPE_REACTION
+		payload = &messengerv1.Reaction{
+			Sender:   tx.Sender,
+			Reaction: tx.Reaction,
+			ParentId: tx.ParentID,
+			PowNonce: tx.PowNonce,
+		}
+	default:
+		err := fmt.Errorf("unknown transaction type: %s", tx.Type)
+		log.Errorf("error encoding transaction: %s\n", err)
+		return nil, err
+	}
+
+	data, err := proto.MarshalOptions{Deterministic: true}.Marshal(payload)
+	if err != nil {
+		log.Errorf("error encoding transaction: %s\n", err)
+		return nil, err
+	}
+	return append([]byte{txVersionV1, byte(tag)}, data...), nil
+}
+
+// decode transaction from bytes back into rollup format
+func decodeTx(txEncoded []byte) (*Transaction, error) {
+	var (
+		tx  *Transaction
+		err error
+	)
+	switch {
+	case len(txEncoded) == 0:
+		err = errors.New("empty transaction")
+	case txEncoded[0] == legacyJSONPrefix:
+		tx, err = decodeLegacyTx(txEncoded)
+	case txEncoded[0] == txVersionV1:
+		tx, err = decodeTxV1(txEncoded[1:])
+	default:
+		err = fmt.Errorf("unknown transaction version: %d", txEncoded[0])
+	}
+	if err != nil {
+		log.Errorf("error decoding transaction: %s\n", err)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// decodeLegacyTx decodes the original JSON transaction format.
+func decodeLegacyTx(txEncoded []byte) (*Transaction, error) {
+	tx := &Transaction{}
+	if err := json.Unmarshal(txEncoded, tx); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// decodeTxV1 decodes a version 1 envelope with the version byte stripped.
+func decodeTxV1(data []byte) (*Transaction, error) {
+	if len(data) == 0 {
+		return nil, errors.New("missing transaction type")
+	}
+	tag, payload := messengerv1.TxType(data[0]), data[1:]
+
+	switch tag {
+	case messengerv1.TxType_TX_TYPE_MESSAGE:
+		msg := &messengerv1.Message{}
+		if err := proto.Unmarshal(payload, msg); err != nil {
+			return nil, err
+		}
+		return &Transaction{
+			Sender:   msg.Sender,
+			Message:  msg.Message,
+			PowNonce: msg.PowNonce,
+			Channel:  msg.Channel,
+		}, nil
+	case messengerv1.TxType_TX_TYPE_REPLY:
+		reply := &messengerv1.Reply{}
+		if err := proto.Unmarshal(payload, reply); err != nil {
+			return nil, err
+		}
+		return &Transaction{
+			Type:     TxTypeReply,
+			Sender:   reply.Sender,
+			Message:  reply.Message,
+			ParentID: reply.ParentId,
+			PowNonce: reply.PowNonce,
+		}, nil
+	case messengerv1.TxType_TX_TYPE_REACTION:
+		reaction := &messengerv1.Reaction{}
+		if err := proto.Unmarshal(payload, reaction); err != nil {
+			return nil, err
+		}
+		return &Transaction{
+			Type:     TxTypeReaction,
+			Sender:   reaction.Sender,
+			Reaction: reaction.Reaction,
+			ParentID: reaction.ParentId,
+			PowNonce: reaction.PowNonce,
+		}, nil
+	default:
+		return nil, fmt.Errorf("unknown transaction type tag: %d", tag)
+	}
+}
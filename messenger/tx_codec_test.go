@@ -0,0 +1,101 @@
+package messenger
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update rewrites the golden files from the current encoder.
+var update = flag.Bool("update", false, "update the golden files in testdata")
+
+// txGoldens are the transactions of the golden files of the v1 envelope.
+var txGoldens = []struct {
+	file string
+	tx   Transaction
+}{
+	{"tx_v1_message.golden", Transaction{Sender: "alice", Message: "hello, world!", Channel: "random", PowNonce: 42}},
+	{"tx_v1_message_default_channel.golden", Transaction{Sender: "alice", Message: "hi"}},
+	{"tx_v1_reply.golden", Transaction{Type: TxTypeReply, Sender: "bob", Message: "hi back", ParentID: "0-0", PowNonce: 7}},
+	{"tx_v1_reaction.golden", Transaction{Type: TxTypeReaction, Sender: "carol", Reaction: "+1", ParentID: "1-2"}},
+}
+
+func readGolden(t *testing.T, file string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestEncodeTxV1Golden(t *testing.T) {
+	for _, g := range txGoldens {
+		t.Run(g.file, func(t *testing.T) {
+			encoded, err := encodeTx(g.tx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *update {
+				if err := os.WriteFile(filepath.Join("testdata", g.file), encoded, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if want := readGolden(t, g.file); !bytes.Equal(encoded, want) {
+				t.Errorf("encodeTx = %x, golden %x", encoded, want)
+			}
+		})
+	}
+}
+
+func TestDecodeTxV1Golden(t *testing.T) {
+	for _, g := range txGoldens {
+		t.Run(g.file, func(t *testing.T) {
+			tx, err := decodeTx(readGolden(t, g.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *tx != g.tx {
+				t.Errorf("decodeTx = %+v, want %+v", *tx, g.tx)
+			}
+		})
+	}
+}
+
+func TestDecodeLegacyJSONGolden(t *testing.T) {
+	for _, g := range []struct {
+		file string
+		tx   Transaction
+	}{
+		{"tx_legacy_message.golden", Transaction{Sender: "astria", Message: "hello, world!"}},
+		{"tx_legacy_reply.golden", Transaction{Type: TxTypeReply, Sender: "bob", Message: "hi back", ParentID: "0-0"}},
+		{"tx_legacy_reaction.golden", Transaction{Type: TxTypeReaction, Sender: "carol", Reaction: "+1", ParentID: "0-0"}},
+	} {
+		t.Run(g.file, func(t *testing.T) {
+			tx, err := decodeTx(readGolden(t, g.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *tx != g.tx {
+				t.Errorf("decodeTx = %+v, want %+v", *tx, g.tx)
+			}
+		})
+	}
+}
+
+func TestDecodeTxInvalid(t *testing.T) {
+	for name, data := range map[string][]byte{
+		"empty":           {},
+		"unknown version": {0x02, 0x01},
+		"missing type":    {txVersionV1},
+		"unknown type":    {txVersionV1, 0x09},
+		"bad payload":     {txVersionV1, 0x01, 0xff},
+		"bad legacy json": []byte("{"),
+	} {
+		if _, err := decodeTx(data); err == nil {
+			t.Errorf("%s: decodeTx succeeded", name)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package messenger
+
+import (
+	"fmt"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Names of the transaction rules, returned to clients when a rule fails.
+const (
+	RuleMaxMessageBytes = "max_message_bytes"
+	RuleMaxSenderLength = "max_sender_length"
+	RuleMaxChannelLen   = "max_channel_length"
+	RuleInvalidUTF8     = "invalid_utf8"
+	RuleControlChars    = "control_characters"
+	RuleMaxTxsPerBlock  = "max_txs_per_block"
+	RuleMalformedJSON   = "malformed_json"
+	RuleInvalidTx       = "invalid_transaction"
+	RuleInsufficientPow = "insufficient_pow"
+	RuleRateLimited     = "rate_limited"
+	RuleMaxBodyBytes    = "max_body_bytes"
+)
+
+// TxLimits are the size and content limits applied to transactions. They are
+// checked in ExecuteBlock, so all nodes of a rollup must use the same values.
+type TxLimits struct {
+	// MaxMessageBytes is the maximum size of a message or reaction in bytes.
+	MaxMessageBytes int
+	// MaxSenderLength is the maximum length of a sender in characters.
+	MaxSenderLength int
+	// MaxChannelLength is the maximum length of a channel in characters.
+	MaxChannelLength int
+	// MaxTxsPerBlock is the maximum number of transactions included in a block.
+	MaxTxsPerBlock int
+	// PowDifficulty is the number of leading zero bits required of each
+	// transaction's PowDigest, 0 disables the proof of work.
+	PowDifficulty int
+}
+
+// MaxBodyBytes returns an upper bound of the size of the JSON body of a
+// transaction within the limits, allowing for every character being escaped.
+func (l TxLimits) MaxBodyBytes() int64 {
+	// an escaped character takes at most 12 bytes, as a surrogate pair
+	chars := 2*l.MaxMessageBytes + l.MaxSenderLength + l.MaxChannelLength
+	return int64(chars)*12 + 1024
+}
+
+// TxRuleError reports which transaction rule a transaction failed.
+type TxRuleError struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+func (e *TxRuleError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Rule, e.Message)
+}
+
+func ruleError(rule string, format string, args ...interface{}) *TxRuleError {
+	return &TxRuleError{
+		Rule:    rule,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+// CheckTx checks a single transaction against the limits. It returns a
+// *TxRuleError describing the first rule that failed.
+func (l TxLimits) CheckTx(tx *Transaction) error {
+	fields := []struct {
+		name  string
+		value string
+		// multiline fields may contain newlines and tabs
+		multiline bool
+	}{
+		{"sender", tx.Sender, false},
+		{"channel", tx.Channel, false},
+		{"message", tx.Message, true},
+		{"parentId", tx.ParentID, false},
+		{"reaction", tx.Reaction, false},
+	}
+	for _, f := range fields {
+		if !utf8.ValidString(f.value) {
+			return ruleError(RuleInvalidUTF8, "%s is not valid UTF-8", f.name)
+		}
+		for _, r := range f.value {
+			if f.multiline && (r == '\n' || r == '\t') {
+				continue
+			}
+			if unicode.IsControl(r) {
+				return ruleError(RuleControlChars, "%s contains control character %U", f.name, r)
+			}
+		}
+	}
+
+	if n := utf8.RuneCountInString(tx.Sender); l.MaxSenderLength > 0 && n > l.MaxSenderLength {
+		return ruleError(RuleMaxSenderLength, "sender is %d characters, limit is %d", n, l.MaxSenderLength)
+	}
+	if n := utf8.RuneCountInString(tx.Channel); l.MaxChannelLength > 0 && n > l.MaxChannelLength {
+		return ruleError(RuleMaxChannelLen, "channel is %d characters, limit is %d", n, l.MaxChannelLength)
+	}
+	if n := len(tx.Message); l.MaxMessageBytes > 0 && n > l.MaxMessageBytes {
+		return ruleError(RuleMaxMessageBytes, "message is %d bytes, limit is %d", n, l.MaxMessageBytes)
+	}
+	if n := len(tx.Reaction); l.MaxMessageBytes > 0 && n > l.MaxMessageBytes {
+		return ruleError(RuleMaxMessageBytes, "reaction is %d bytes, limit is %d", n, l.MaxMessageBytes)
+	}
+	if !CheckPow(tx, l.PowDifficulty) {
+		return ruleError(RuleInsufficientPow, "powNonce does not solve a proof of work of difficulty %d", l.PowDifficulty)
+	}
+	return nil
+}
@@ -0,0 +1,657 @@
+package messenger
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	log "github.com/sirupsen/logrus"
+)
+
+// Webhooks POST the transactions events of new blocks to registered URLs,
+// as the /ws and /events endpoints push them. Every hook is an event client
+// of the hub, filtered by its channels, senders and commitment level.
+//
+// The request body is the WSEvent JSON envelope. Requests carry the
+// headers:
+//
+//	X-Messenger-Webhook-Id   the hook id
+//	X-Messenger-Delivery-Id  the delivery id, the same across retries
+//	X-Messenger-Timestamp    unix seconds when the request was sent
+//	X-Messenger-Signature    sha256=<hex HMAC-SHA256 of "<timestamp>.<body>"
+//	                         keyed with the hook secret>
+//
+// A delivery succeeds on a 2xx response. Other responses and errors are
+// retried with exponential backoff, except 4xx responses other than 408 and
+// 429, until maxAttempts is reached.
+//
+// As hooks make the node send requests to any URL, the webhooks API always
+// requires the admin token or the webhooks scope, and hooks are not delivered
+// to loopback, link-local or private addresses unless allowed by
+// WEBHOOK_ALLOWED_NETWORKS. Addresses are checked when connecting, so that
+// host names resolving to them and redirects to them are refused too.
+
+const (
+	webhookSignatureHeader  = "X-Messenger-Signature"
+	webhookTimestampHeader  = "X-Messenger-Timestamp"
+	webhookIDHeader         = "X-Messenger-Webhook-Id"
+	webhookDeliveryIDHeader = "X-Messenger-Delivery-Id"
+
+	// webhookInitialBackoff doubles after every failed attempt up to
+	// webhookMaxBackoff
+	webhookInitialBackoff = time.Second
+	webhookMaxBackoff     = time.Minute
+	// webhookDeliveryLogSize is the number of deliveries kept per hook
+	webhookDeliveryLogSize = 100
+)
+
+// WebhookConfig is a webhook as registered in the webhooks file or through
+// the REST API. The secret is generated if empty.
+type WebhookConfig struct {
+	URL        string          `json:"url"`
+	Secret     string          `json:"secret,omitempty"`
+	Channels   []string        `json:"channels,omitempty"`
+	Senders    []string        `json:"senders,omitempty"`
+	Commitment CommitmentLevel `json:"commitment,omitempty"`
+}
+
+// Webhook is a registered webhook.
+type Webhook struct {
+	ID string `json:"id"`
+	WebhookConfig
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// WebhookDelivery is the outcome of POSTing one event to a hook.
+type WebhookDelivery struct {
+	ID         string          `json:"id"`
+	Height     uint32          `json:"height"`
+	Commitment CommitmentLevel `json:"commitment"`
+	Delivered  bool            `json:"delivered"`
+	Attempts   int             `json:"attempts"`
+	StatusCode int             `json:"statusCode,omitempty"`
+	Error      string          `json:"error,omitempty"`
+	Time       time.Time       `json:"time"`
+}
+
+// webhookEntry is a registered hook and the state of its delivery worker.
+type webhookEntry struct {
+	hook Webhook
+	// stop is closed when the hook is removed or the registry stopped
+	stop     chan struct{}
+	stopOnce sync.Once
+	// deliveries is the delivery log, oldest first, guarded by the
+	// registry's mutex
+	deliveries []WebhookDelivery
+}
+
+// WebhookRegistry holds the registered webhooks and runs a delivery worker
+// per hook once started.
+type WebhookRegistry struct {
+	hub         *EventHub
+	client      *http.Client
+	maxAttempts int
+	// allowedNetworks may be delivered to even if they are internal
+	allowedNetworks []*net.IPNet
+
+	mu      sync.RWMutex
+	hooks   map[string]*webhookEntry
+	started bool
+	stopped bool
+	// workers tracks the running delivery workers
+	workers sync.WaitGroup
+}
+
+// halt stops the hook's delivery worker. It is safe to call more than once.
+func (e *webhookEntry) halt() {
+	e.stopOnce.Do(func() {
+		close(e.stop)
+	})
+}
+
+// NewWebhookRegistry creates a registry delivering with the given timeout and
+// attempts. Loopback, link-local and private addresses are refused unless
+// they are in allowedNetworks.
+func NewWebhookRegistry(hub *EventHub, timeout time.Duration, maxAttempts int, allowedNetworks []*net.IPNet) *WebhookRegistry {
+	r := &WebhookRegistry{
+		hub:             hub,
+		maxAttempts:     max(maxAttempts, 1),
+		allowedNetworks: allowedNetworks,
+		hooks:           make(map[string]*webhookEntry),
+	}
+	dialer := &net.Dialer{
+		Timeout:   timeout,
+		KeepAlive: 30 * time.Second,
+		Control:   r.checkDial,
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// a proxy would be the only address checked
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	r.client = &http.Client{Timeout: timeout, Transport: transport}
+	return r
+}
+
+// ParseNetworks parses a list of CIDR networks, such as 10.0.0.0/8.
+func ParseNetworks(cidrs []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// checkIP returns an error if hooks must not be delivered to an address.
+func (r *WebhookRegistry) checkIP(ip net.IP) error {
+	for _, network := range r.allowedNetworks {
+		if network.Contains(ip) {
+			return nil
+		}
+	}
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsPrivate() || ip.IsUnspecified() {
+		return fmt.Errorf("address %s is internal, see WEBHOOK_ALLOWED_NETWORKS", ip)
+	}
+	return nil
+}
+
+// checkDial checks the address of every connection made by deliveries.
+func (r *WebhookRegistry) checkDial(network string, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("invalid address %s", address)
+	}
+	return r.checkIP(ip)
+}
+
+// LoadWebhooksFile reads a JSON list of webhook configs.
+func LoadWebhooksFile(path string) ([]WebhookConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var configs []WebhookConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, fmt.Errorf("invalid webhooks file %s: %w", path, err)
+	}
+	return configs, nil
+}
+
+// SignWebhookPayload returns the signature header value of a webhook
+// request body sent at the given unix timestamp.
+func SignWebhookPayload(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// randomID returns a random hex id.
+func randomID(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validate checks a hook config. Hosts given as internal addresses are
+// refused right away, other hosts when delivering.
+func (r *WebhookRegistry) validate(c *WebhookConfig) error {
+	u, err := url.Parse(c.URL)
+	if err != nil {
+		return fmt.Errorf("invalid url: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https url")
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		if err := r.checkIP(ip); err != nil {
+			return err
+		}
+	}
+	if c.Commitment != "" && !c.Commitment.valid() {
+		return fmt.Errorf("unknown commitment level: %s", c.Commitment)
+	}
+	return nil
+}
+
+// Add registers a webhook, starting its delivery worker if the registry is
+// started.
+func (r *WebhookRegistry) Add(config WebhookConfig) (Webhook, error) {
+	if err := r.validate(&config); err != nil {
+		return Webhook{}, err
+	}
+	if config.Secret == "" {
+		secret, err := randomID(32)
+		if err != nil {
+			return Webhook{}, fmt.Errorf("error generating secret: %w", err)
+		}
+		config.Secret = secret
+	}
+	if config.Commitment == "" {
+		config.Commitment = CommitmentExecuted
+	}
+	id, err := randomID(8)
+	if err != nil {
+		return Webhook{}, fmt.Errorf("error generating id: %w", err)
+	}
+	entry := &webhookEntry{
+		hook: Webhook{
+			ID:            id,
+			WebhookConfig: config,
+			CreatedAt:     time.Now().UTC(),
+		},
+		stop: make(chan struct{}),
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks[entry.hook.ID] = entry
+	if r.started {
+		r.startWorker(entry)
+	}
+	log.WithFields(log.Fields{
+		"webhookID": entry.hook.ID,
+		"url":       entry.hook.URL,
+	}).Info("webhook registered")
+	return entry.hook, nil
+}
+
+// Remove unregisters a webhook and stops its delivery worker.
+func (r *WebhookRegistry) Remove(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry, ok := r.hooks[id]
+	if !ok {
+		return false
+	}
+	delete(r.hooks, id)
+	entry.halt()
+	log.WithField("webhookID", id).Info("webhook removed")
+	return true
+}
+
+// List returns the registered webhooks without their secrets, oldest first.
+func (r *WebhookRegistry) List() []Webhook {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	hooks := make([]Webhook, 0, len(r.hooks))
+	for _, entry := range r.hooks {
+		hook := entry.hook
+		hook.Secret = ""
+		hooks = append(hooks, hook)
+	}
+	sort.Slice(hooks, func(i, j int) bool {
+		return hooks[i].CreatedAt.Before(hooks[j].CreatedAt)
+	})
+	return hooks
+}
+
+// Get returns a webhook without its secret.
+func (r *WebhookRegistry) Get(id string) (Webhook, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.hooks[id]
+	if !ok {
+		return Webhook{}, false
+	}
+	hook := entry.hook
+	hook.Secret = ""
+	return hook, true
+}
+
+// Deliveries returns the latest deliveries of a webhook, newest first.
+func (r *WebhookRegistry) Deliveries(id string) ([]WebhookDelivery, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	entry, ok := r.hooks[id]
+	if !ok {
+		return nil, false
+	}
+	deliveries := make([]WebhookDelivery, len(entry.deliveries))
+	for i, delivery := range entry.deliveries {
+		deliveries[len(deliveries)-1-i] = delivery
+	}
+	return deliveries, true
+}
+
+// Start starts the delivery workers, unless the registry was stopped. The
+// hub must be running.
+func (r *WebhookRegistry) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.stopped {
+		return
+	}
+	r.started = true
+	for _, entry := range r.hooks {
+		r.startWorker(entry)
+	}
+}
+
+// Stop stops the delivery workers, waiting for deliveries in flight until
+// ctx is done. Events not delivered yet are dropped.
+func (r *WebhookRegistry) Stop(ctx context.Context) error {
+	r.mu.Lock()
+	r.started = false
+	r.stopped = true
+	for _, entry := range r.hooks {
+		entry.halt()
+	}
+	r.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		r.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("webhook deliveries still in flight: %w", ctx.Err())
+	}
+}
+
+// startWorker starts the delivery worker of a hook. The caller must hold mu.
+func (r *WebhookRegistry) startWorker(entry *webhookEntry) {
+	r.workers.Add(1)
+	go func() {
+		defer r.workers.Done()
+		r.run(entry)
+	}()
+}
+
+// run delivers the blocks executed after the hook was started until it is
+// removed. If the hub disconnects the hook for lagging behind, it registers
+// again and resumes after the last delivered block.
+func (r *WebhookRegistry) run(entry *webhookEntry) {
+	hook := entry.hook
+	next := r.hub.rollupBlocks.CommittedHeight(hook.Commitment) + 1
+	for {
+		transport := newHandlerTransport("webhook", hook.URL)
+		client := NewEventClient(transport, r.hub)
+		if _, err := client.subscribe(hook.Channels, hook.Senders, hook.Commitment); err != nil {
+			log.Errorf("error subscribing webhook %s: %s\n", hook.ID, err)
+			return
+		}
+		r.hub.RegisterFrom(client, next)
+
+		next = r.deliverEvents(entry, client, transport, next)
+		close(client.done)
+		r.hub.Unregister(client)
+		// after a rollback, deliver the blocks executed again at the removed
+		// heights
+		next = min(next, r.hub.rollupBlocks.CommittedHeight(hook.Commitment)+1)
+
+		select {
+		case <-entry.stop:
+			return
+		default:
+			log.WithField("webhookID", hook.ID).Warnf("webhook disconnected, resuming from height %d", next)
+		}
+	}
+}
+
+// deliverEvents delivers the transactions events of a hook's event client
+// until the hook is removed or the client is disconnected. It returns the
+// height following the last delivered block.
+func (r *WebhookRegistry) deliverEvents(entry *webhookEntry, client *EventClient, transport *handlerTransport, next uint32) uint32 {
+	for {
+		select {
+		case event := <-client.egress:
+			if event.Type != WSEventTransactions {
+				continue
+			}
+			client.nextSeq(&event)
+			r.deliver(entry, event)
+			next = event.Height + 1
+		case <-transport.closed:
+			return next
+		case <-entry.stop:
+			return next
+		}
+	}
+}
+
+// deliver POSTs an event to a hook, retrying with backoff, and records the
+// outcome in the delivery log.
+func (r *WebhookRegistry) deliver(entry *webhookEntry, event WSEvent) {
+	id, err := randomID(8)
+	if err != nil {
+		log.Errorf("error generating webhook delivery id: %s\n", err)
+		return
+	}
+	delivery := WebhookDelivery{
+		ID:         id,
+		Height:     event.Height,
+		Commitment: event.Commitment,
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		log.Errorf("error marshalling webhook event: %s\n", err)
+		return
+	}
+
+	backoff := webhookInitialBackoff
+	for delivery.Attempts < r.maxAttempts {
+		delivery.Attempts++
+		statusCode, err := r.post(&entry.hook, delivery.ID, body)
+		delivery.StatusCode = statusCode
+		delivery.Error = ""
+		if err != nil {
+			delivery.Error = err.Error()
+		}
+		if err == nil && statusCode >= 200 && statusCode < 300 {
+			delivery.Delivered = true
+			break
+		}
+		if statusCode >= 400 && statusCode < 500 && statusCode != http.StatusRequestTimeout && statusCode != http.StatusTooManyRequests {
+			break
+		}
+		if delivery.Attempts == r.maxAttempts {
+			break
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-entry.stop:
+			timer.Stop()
+			return
+		}
+		backoff = min(backoff*2, webhookMaxBackoff)
+	}
+	delivery.Time = time.Now().UTC()
+
+	fields := log.Fields{
+		"webhookID":  entry.hook.ID,
+		"deliveryID": delivery.ID,
+		fieldHeight:  delivery.Height,
+		"attempts":   delivery.Attempts,
+		"statusCode": delivery.StatusCode,
+	}
+	if delivery.Delivered {
+		log.WithFields(fields).Debug("webhook delivered")
+	} else {
+		log.WithFields(fields).Warnf("webhook delivery failed: %s", delivery.Error)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	entry.deliveries = append(entry.deliveries, delivery)
+	if len(entry.deliveries) > webhookDeliveryLogSize {
+		entry.deliveries = entry.deliveries[len(entry.deliveries)-webhookDeliveryLogSize:]
+	}
+}
+
+// post sends one signed delivery attempt and returns the response status.
+func (r *WebhookRegistry) post(hook *Webhook, deliveryID string, body []byte) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := time.Now().Unix()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "messenger-rollup-webhook")
+	req.Header.Set(webhookIDHeader, hook.ID)
+	req.Header.Set(webhookDeliveryIDHeader, deliveryID)
+	req.Header.Set(webhookTimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(webhookSignatureHeader, SignWebhookPayload(hook.Secret, timestamp, body))
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// setupWebhookRoutes adds the webhooks API routes, if the admin token or API
+// authentication is set. Unlike other routes they are never open.
+func (a *App) setupWebhookRoutes() {
+	if a.adminToken == "" && a.authenticator == nil {
+		return
+	}
+	a.restRouter.HandleFunc("/webhooks", a.withWebhookAuth(a.listWebhooks)).Methods("GET")
+	a.restRouter.HandleFunc("/webhooks", a.withWebhookAuth(a.createWebhook)).Methods("POST")
+	a.restRouter.HandleFunc("/webhooks/{id}", a.withWebhookAuth(a.getWebhook)).Methods("GET")
+	a.restRouter.HandleFunc("/webhooks/{id}", a.withWebhookAuth(a.deleteWebhook)).Methods("DELETE")
+	a.restRouter.HandleFunc("/webhooks/{id}/deliveries", a.withWebhookAuth(a.getWebhookDeliveries)).Methods("GET")
+}
+
+// withWebhookAuth requires the admin token or, with API authentication, the
+// webhooks scope.
+func (a *App) withWebhookAuth(next http.HandlerFunc) http.HandlerFunc {
+	scoped := a.withScope(ScopeWebhooks, next)
+	return func(w http.ResponseWriter, r *http.Request) {
+		if a.adminToken != "" && adminBearer(r.Header.Get("Authorization"), a.adminToken) {
+			next(w, r)
+			return
+		}
+		if a.authenticator == nil {
+			requestLog(r.Context()).Warnf("unauthorized webhook request to %s\n", r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		scoped(w, r)
+	}
+}
+
+// list registered webhooks
+func (a *App) listWebhooks(w http.ResponseWriter, r *http.Request) {
+	hooksJson, err := json.Marshal(a.webhooks.List())
+	if err != nil {
+		requestLog(r.Context()).Errorf("error marshalling webhooks: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(hooksJson)
+}
+
+// register a webhook, the response is the only one including the secret
+func (a *App) createWebhook(w http.ResponseWriter, r *http.Request) {
+	logger := requestLog(r.Context())
+	var config WebhookConfig
+	if err := json.NewDecoder(r.Body).Decode(&config); err != nil {
+		logger.Errorf("error decoding webhook: %s\n", err)
+		http.Error(w, "malformed webhook", http.StatusBadRequest)
+		return
+	}
+
+	hook, err := a.webhooks.Add(config)
+	if err != nil {
+		logger.Errorf("invalid webhook: %s\n", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	hookJson, err := json.Marshal(hook)
+	if err != nil {
+		logger.Errorf("error marshalling webhook: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusCreated)
+	w.Write(hookJson)
+}
+
+// get a webhook by id
+func (a *App) getWebhook(w http.ResponseWriter, r *http.Request) {
+	hook, ok := a.webhooks.Get(mux.Vars(r)["id"])
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	hookJson, err := json.Marshal(hook)
+	if err != nil {
+		requestLog(r.Context()).Errorf("error marshalling webhook: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(hookJson)
+}
+
+// remove a webhook
+func (a *App) deleteWebhook(w http.ResponseWriter, r *http.Request) {
+	if !a.webhooks.Remove(mux.Vars(r)["id"]) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// get the latest deliveries of a webhook
+func (a *App) getWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	deliveries, ok := a.webhooks.Deliveries(id)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	deliveriesJson, err := json.Marshal(map[string]interface{}{
+		"id":         id,
+		"deliveries": deliveries,
+	})
+	if err != nil {
+		requestLog(r.Context()).Errorf("error marshalling webhook deliveries: %s\n", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Write(deliveriesJson)
+}
@@ -0,0 +1,49 @@
+package messenger
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebhookValidateRefusesInternalAddresses(t *testing.T) {
+	r := NewWebhookRegistry(nil, time.Second, 1, nil)
+	for _, url := range []string{
+		"http://127.0.0.1/hook",
+		"http://[::1]:8080/hook",
+		"http://169.254.169.254/latest/meta-data",
+		"http://10.0.0.1/hook",
+		"http://192.168.1.1/hook",
+		"http://0.0.0.0/hook",
+	} {
+		if err := r.validate(&WebhookConfig{URL: url}); err == nil {
+			t.Errorf("%s: validate succeeded", url)
+		}
+	}
+	if err := r.validate(&WebhookConfig{URL: "https://93.184.216.34/hook"}); err != nil {
+		t.Errorf("public address: %s", err)
+	}
+}
+
+func TestWebhookDeliveryChecksDialedAddress(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	// a host name hides the loopback address until it is dialed
+	hook := &Webhook{WebhookConfig: WebhookConfig{URL: strings.Replace(server.URL, "127.0.0.1", "localhost", 1)}}
+
+	r := NewWebhookRegistry(nil, time.Second, 1, nil)
+	if _, err := r.post(hook, "1", []byte("{}")); err == nil || !strings.Contains(err.Error(), "internal") {
+		t.Fatalf("post to loopback: got %v, want an internal address error", err)
+	}
+
+	allowed, err := ParseNetworks([]string{"127.0.0.0/8", "::1/128"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = NewWebhookRegistry(nil, time.Second, 1, allowed)
+	if status, err := r.post(hook, "1", []byte("{}")); err != nil || status != http.StatusOK {
+		t.Fatalf("post to allowed loopback: got %d %v", status, err)
+	}
+}
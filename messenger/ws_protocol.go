@@ -0,0 +1,179 @@
+package messenger
+
+import "sort"
+
+// WebSocket protocol
+//
+// Every frame sent by the server on /ws is a JSON WSEvent envelope:
+//
+//	{"type": "transactions", "seq": 7, "height": 12, "hash": "ab12..",
+//	 "commitment": "executed", "data": [<clientTx>, ...]}
+//
+// seq increases by one for every event sent on a connection, so clients can
+// detect gaps. Event types:
+//
+//	transactions  the transactions of a block matching the subscription, at
+//	              the subscribed commitment level. data is a list of
+//	              transactions as returned by /recent, reactions also carry
+//	              the updated reaction counts of their parent.
+//	commitment    the soft and firm heights changed. data is {"soft", "firm"}.
+//	ack           a client request succeeded. id is the request id, data is
+//	              the resulting subscription for subscribe/unsubscribe. For
+//	              subscribe, height is the current height at the subscribed
+//	              level: every later block is pushed under the new
+//	              subscription, so clients can resume from height + 1.
+//	live          a replay caught up and live blocks follow. height is the
+//	              last replayed height.
+//	pong          reply to a ping request, id is the request id.
+//	error         a client request failed. id is the request id if it could
+//	              be read, data is {"message"}.
+//
+// Clients send JSON WSRequest frames:
+//
+//	{"type": "subscribe", "id": "1", "channels": ["general"], "senders": ["alice"], "commitment": "soft"}
+//	{"type": "unsubscribe", "id": "2", "senders": ["alice"]}
+//	{"type": "resume", "id": "3", "fromHeight": 10}
+//	{"type": "ping", "id": "4"}
+//
+// subscribe adds the channels and senders to the subscription and, if set,
+// replaces the commitment level. unsubscribe removes them. An empty channel
+// or sender list matches every channel or sender. New connections start
+// subscribed to every transaction at the executed level.
+//
+// resume replays every block from fromHeight up to the current height at the
+// subscribed commitment level, then switches back to live blocks followed by
+// a live event. A fromHeight past the current height resumes from the next
+// block. No block is pushed twice or skipped across the switch. The
+// resume is acked before the first replayed block, block events received
+// before the ack were pushed live before the resume.
+// Connecting to /ws?fromHeight=N resumes from N right away.
+
+// CommitmentLevel is the commitment a block must reach before its
+// transactions are pushed to a subscriber.
+type CommitmentLevel string
+
+const (
+	// CommitmentExecuted pushes blocks as soon as they are executed.
+	CommitmentExecuted CommitmentLevel = "executed"
+	// CommitmentSoft pushes blocks once they are soft committed.
+	CommitmentSoft CommitmentLevel = "soft"
+	// CommitmentFirm pushes blocks once they are firm committed.
+	CommitmentFirm CommitmentLevel = "firm"
+)
+
+func (l CommitmentLevel) valid() bool {
+	switch l {
+	case CommitmentExecuted, CommitmentSoft, CommitmentFirm:
+		return true
+	}
+	return false
+}
+
+// WSEventType is the type of a server to client frame.
+type WSEventType string
+
+const (
+	WSEventTransactions WSEventType = "transactions"
+	WSEventCommitment   WSEventType = "commitment"
+	WSEventAck          WSEventType = "ack"
+	WSEventLive         WSEventType = "live"
+	WSEventPong         WSEventType = "pong"
+	WSEventError        WSEventType = "error"
+)
+
+// WSEvent is the envelope of every server to client frame.
+type WSEvent struct {
+	Type       WSEventType     `json:"type"`
+	Seq        uint64          `json:"seq"`
+	Height     uint32          `json:"height,omitempty"`
+	Hash       string          `json:"hash,omitempty"`
+	Commitment CommitmentLevel `json:"commitment,omitempty"`
+	ID         string          `json:"id,omitempty"`
+	Data       interface{}     `json:"data,omitempty"`
+}
+
+// WSRequestType is the type of a client to server frame.
+type WSRequestType string
+
+const (
+	WSRequestSubscribe   WSRequestType = "subscribe"
+	WSRequestUnsubscribe WSRequestType = "unsubscribe"
+	WSRequestResume      WSRequestType = "resume"
+	WSRequestPing        WSRequestType = "ping"
+)
+
+// WSRequest is a client to server frame.
+type WSRequest struct {
+	Type       WSRequestType   `json:"type"`
+	ID         string          `json:"id,omitempty"`
+	Channels   []string        `json:"channels,omitempty"`
+	Senders    []string        `json:"senders,omitempty"`
+	Commitment CommitmentLevel `json:"commitment,omitempty"`
+	FromHeight *uint32         `json:"fromHeight,omitempty"`
+}
+
+// WSSubscription is the set of transactions pushed to a client.
+type WSSubscription struct {
+	Channels   []string        `json:"channels"`
+	Senders    []string        `json:"senders"`
+	Commitment CommitmentLevel `json:"commitment"`
+}
+
+// wsErrorData is the data of an error event.
+type wsErrorData struct {
+	Message string `json:"message"`
+}
+
+// subscription is a client's filter over pushed transactions.
+type subscription struct {
+	channels   map[string]bool
+	senders    map[string]bool
+	commitment CommitmentLevel
+}
+
+func newSubscription() *subscription {
+	return &subscription{
+		channels:   make(map[string]bool),
+		senders:    make(map[string]bool),
+		commitment: CommitmentExecuted,
+	}
+}
+
+// matches reports whether a transaction passes the channel and sender filters.
+func (s *subscription) matches(tx *clientTx) bool {
+	if len(s.channels) > 0 && !s.channels[tx.ChannelName()] {
+		return false
+	}
+	if len(s.senders) > 0 && !s.senders[tx.Sender] {
+		return false
+	}
+	return true
+}
+
+// filter returns the transactions passing the channel and sender filters.
+func (s *subscription) filter(txs []clientTx) []clientTx {
+	filtered := []clientTx{}
+	for i := range txs {
+		if s.matches(&txs[i]) {
+			filtered = append(filtered, txs[i])
+		}
+	}
+	return filtered
+}
+
+func (s *subscription) toWS() WSSubscription {
+	sub := WSSubscription{
+		Channels:   []string{},
+		Senders:    []string{},
+		Commitment: s.commitment,
+	}
+	for channel := range s.channels {
+		sub.Channels = append(sub.Channels, channel)
+	}
+	for sender := range s.senders {
+		sub.Senders = append(sub.Senders, sender)
+	}
+	sort.Strings(sub.Channels)
+	sort.Strings(sub.Senders)
+	return sub
+}
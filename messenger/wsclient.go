@@ -1,6 +1,9 @@
 package messenger
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -10,57 +13,158 @@ import (
 var (
 	pongWait     = 10 * time.Second
 	pingInterval = (pongWait * 9) / 10
+
+	// maxRequestSize is the maximum size of a client request frame
+	maxRequestSize int64 = 4096
 )
 
-type WSClientList map[*WSClient]bool
+// wsTransport writes events to a WebSocket connection.
+type wsTransport struct {
+	conn *websocket.Conn
+}
+
+func (t wsTransport) kind() string {
+	return "ws"
+}
+
+func (t wsTransport) remoteAddr() string {
+	return t.conn.RemoteAddr().String()
+}
+
+func (t wsTransport) close(reason string) {
+	if reason != "" {
+		code := websocket.CloseTryAgainLater
+		if reason == reasonShutdown {
+			code = websocket.CloseGoingAway
+		}
+		msg := websocket.FormatCloseMessage(code, reason)
+		if err := t.conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(time.Second)); err != nil {
+			log.Debugf("error sending close frame: %v", err)
+		}
+	}
+	t.conn.Close()
+}
 
+// WSClient is an event client connected over WebSocket, which can change its
+// subscription with requests sent over the connection.
 type WSClient struct {
-	conn   *websocket.Conn
-	app    *App
-	egress chan []byte
+	*EventClient
+	conn *websocket.Conn
 }
 
-func NewWSClient(conn *websocket.Conn, app *App) *WSClient {
+func NewWSClient(conn *websocket.Conn, hub *EventHub) *WSClient {
 	return &WSClient{
-		conn:   conn,
-		app:    app,
-		egress: make(chan []byte, 50),
+		EventClient: NewEventClient(wsTransport{conn}, hub),
+		conn:        conn,
 	}
 }
 
+// WaitForMessages writes queued events and pings to the client until the
+// connection fails or the read loop exits.
 func (c *WSClient) WaitForMessages() {
 	ticker := time.NewTicker(pingInterval)
 	defer func() {
 		ticker.Stop()
-		c.app.removeWSClient(c)
+		c.hub.Unregister(c.EventClient)
 	}()
 
-	c.conn.SetPongHandler(c.pongHandler)
-
-	if err := c.conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
-		log.Errorf("error while setting read dealine: %v", err)
-		return
-	}
-
 	for {
 		select {
-		case message, ok := <-c.egress:
+		case event, ok := <-c.egress:
 			if !ok {
 				if err := c.conn.WriteMessage(websocket.CloseMessage, nil); err != nil {
 					log.Errorf("connection closed: %v", err)
 				}
 				return
 			}
+			c.nextSeq(&event)
+			message, err := json.Marshal(event)
+			if err != nil {
+				log.Errorf("error marshalling ws event: %v", err)
+				continue
+			}
 			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
 				log.Errorf("Error writing message to ws client: %v", err)
+				return
 			}
-			log.Debugf("sent message to ws client: %s", message)
+			// events hold the message contents, which are only logged at
+			// trace level
+			log.WithField(fieldClientID, c.id).Tracef("sent message to ws client: %s", message)
 		case <-ticker.C:
 			if err := c.conn.WriteMessage(websocket.PingMessage, []byte{}); err != nil {
 				log.Errorf("error sending ping: %v", err)
 				return
 			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// ReadMessages reads and handles client requests until the connection fails.
+func (c *WSClient) ReadMessages() {
+	defer func() {
+		close(c.done)
+		c.hub.Unregister(c.EventClient)
+	}()
+
+	c.conn.SetReadLimit(maxRequestSize)
+	c.conn.SetPongHandler(c.pongHandler)
+	if err := c.conn.SetReadDeadline(time.Now().Add(pongWait)); err != nil {
+		log.Errorf("error while setting read dealine: %v", err)
+		return
+	}
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Errorf("error reading from ws client: %v", err)
+			}
+			return
+		}
+
+		var req WSRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			c.sendError("", fmt.Errorf("malformed request: %w", err))
+			continue
+		}
+		c.handleRequest(&req)
+	}
+}
+
+func (c *WSClient) handleRequest(req *WSRequest) {
+	switch req.Type {
+	case WSRequestSubscribe:
+		sub, err := c.subscribe(req.Channels, req.Senders, req.Commitment)
+		if err != nil {
+			c.sendError(req.ID, err)
+			return
+		}
+		// blocks above the current height are pushed under the new
+		// subscription
+		height := c.hub.rollupBlocks.CommittedHeight(sub.Commitment)
+		c.send(WSEvent{Type: WSEventAck, ID: req.ID, Height: height, Commitment: sub.Commitment, Data: sub})
+	case WSRequestUnsubscribe:
+		sub := c.unsubscribe(req.Channels, req.Senders)
+		c.send(WSEvent{Type: WSEventAck, ID: req.ID, Data: sub})
+	case WSRequestResume:
+		if req.FromHeight == nil {
+			c.sendError(req.ID, errors.New("resume requires fromHeight"))
+			return
+		}
+		if err := c.stopLive(); err != nil {
+			c.sendError(req.ID, err)
+			return
 		}
+		// ack before replaying, so that every block event following the
+		// ack belongs to the replay
+		c.send(WSEvent{Type: WSEventAck, ID: req.ID})
+		go c.replay(*req.FromHeight)
+	case WSRequestPing:
+		c.send(WSEvent{Type: WSEventPong, ID: req.ID})
+	default:
+		c.sendError(req.ID, fmt.Errorf("unknown request type: %s", req.Type))
 	}
 }
 